@@ -17,7 +17,7 @@ import (
 func main() {
 	cliFlags := flag.NewFlagSet("jsonrpc-watch", flag.ExitOnError)
 	pServerUrl := cliFlags.String("c", "", "jsonrpc server url, wss?, h2c? prefixed, can be in env JSONRPC_CONNECT, default is ws://127.0.0.1:9990")
-	pRetry := cliFlags.Int("retry", 1, "retry times")
+	pRetry := cliFlags.Int("retry", 1, "reconnect attempts, 0 means retrying forever")
 	var headerFlags jsoffnet.HeaderFlags
 	cliFlags.Var(&headerFlags, "header", "attached http headers")
 	cliFlags.Parse(os.Args[1:])
@@ -57,8 +57,14 @@ func main() {
 		params = p1
 	}
 
-	// jsoff client
-	c, err := jsoffnet.NewClient(serverUrl)
+	// jsoff client, reconnects when the connection drops
+	opts := jsoffnet.ClientOptions{
+		Reconnect: jsoffnet.ReconnectOptions{
+			Enabled:     true,
+			MaxAttempts: *pRetry,
+		},
+	}
+	c, err := jsoffnet.NewClient(serverUrl, opts)
 	if err != nil {
 		log.Fatalf("fail to find jsonrpc client: %s", err)
 		os.Exit(1)
@@ -82,74 +88,86 @@ func main() {
 	})
 
 	watcher := &jsonrpcWatcher{
-		retrylimit: *pRetry,
-		sc:         sc,
-		method:     method,
-		params:     params,
+		sc:        sc,
+		reconnect: opts.Reconnect,
+		method:    method,
+		params:    params,
 	}
 
-	watcher.run()
-
+	if err := watcher.run(); err != nil {
+		log.Errorf("watch error %s, %s", reflect.TypeOf(err), err)
+		os.Exit(1)
+	}
 }
 
 type jsonrpcWatcher struct {
-	sc           jsoffnet.Streamable
-	method       string
-	params       []any
-	retrylimit   int
-	connectretry int
+	sc        jsoffnet.Streamable
+	reconnect jsoffnet.ReconnectOptions
+	method    string
+	params    []any
 }
 
-func (self *jsonrpcWatcher) run() {
-	for {
-		if err := self.connect(); err != nil {
-			if errors.Is(err, jsoffnet.TransportConnectFailed) ||
-				errors.Is(err, jsoffnet.TransportClosed) ||
-				errors.Is(err, io.EOF) {
-
-				self.connectretry++
-				log.Infof("connect failed %d/%d times", self.connectretry, self.retrylimit)
-				if self.connectretry >= self.retrylimit {
-					break
-				} else {
-					time.Sleep(1 * time.Second)
-					continue
-				}
-			} else {
-				log.Errorf("watch error %s, %s", reflect.TypeOf(err), err)
-				break
-			}
-		} else {
-			break
+// connect connects the server, the failed attempts are retried by
+// the reconnect policy
+func (self *jsonrpcWatcher) connect(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := self.sc.Connect(ctx)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, jsoffnet.TransportConnectFailed) &&
+			!errors.Is(err, jsoffnet.TransportClosed) &&
+			!errors.Is(err, io.EOF) {
+			return err
+		}
+		if self.reconnect.MaxAttempts > 0 && attempt >= self.reconnect.MaxAttempts {
+			return err
 		}
+		delay := self.reconnect.Backoff(attempt)
+		log.Infof("connect failed %d/%d times, retry after %s", attempt, self.reconnect.MaxAttempts, delay)
+		time.Sleep(delay)
 	}
 }
 
-func (self *jsonrpcWatcher) connect() error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func (self *jsonrpcWatcher) run() error {
+	ctx := context.Background()
+	if err := self.connect(ctx); err != nil {
+		return err
+	}
 
-	if err := self.sc.Connect(ctx); err != nil {
+	// call the method again on the new connection
+	self.sc.OnReconnect(func() {
+		if err := self.call(ctx); err != nil {
+			log.Warnf("call after reconnect error: %s", err)
+		}
+	})
+	if err := self.call(ctx); err != nil {
 		return err
 	}
 
-	// reset connectretry after connected
-	self.connectretry = 0
+	// wait until the client gives up reconnecting
+	err := self.sc.Wait()
+	if errors.Is(err, jsoffnet.TransportClosed) || errors.Is(err, io.EOF) {
+		log.Infof("connection closed")
+		return nil
+	}
+	return err
+}
 
-	if self.method != "" {
-		reqId := jsoff.NewUuid()
-		reqmsg := jsoff.NewRequestMessage(reqId, self.method, self.params)
-		resmsg, err := self.sc.Call(ctx, reqmsg)
-		if err != nil {
-			log.Panicf("rpc error: %s", err)
-			os.Exit(1)
-		}
-		repr, err := jsoff.EncodePretty(resmsg)
-		if err != nil {
-			log.Panicf("encode pretty error %s", err)
-		}
-		fmt.Println(repr)
+func (self *jsonrpcWatcher) call(ctx context.Context) error {
+	if self.method == "" {
+		return nil
+	}
+	reqId := jsoff.NewUuid()
+	reqmsg := jsoff.NewRequestMessage(reqId, self.method, self.params)
+	resmsg, err := self.sc.Call(ctx, reqmsg)
+	if err != nil {
+		return errors.Wrap(err, "rpc error")
+	}
+	repr, err := jsoff.EncodePretty(resmsg)
+	if err != nil {
+		return errors.Wrap(err, "encode pretty error")
 	}
-	// wait loop
-	return self.sc.Wait()
+	fmt.Println(repr)
+	return nil
 }
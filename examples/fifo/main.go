@@ -14,7 +14,7 @@ func fifoActor() *jsoffnet.Actor {
 	fifo := make([]any, 0)
 	lock := sync.RWMutex{}
 
-	subs := map[string]jsoffnet.RPCSession{}
+	subs := map[string]*jsoffnet.SubscriptionSink{}
 	actor := jsoffnet.NewActor()
 
 	actor.On("example_echo", func(params []any) (any, error) {
@@ -39,12 +39,9 @@ func fifoActor() *jsoffnet.Actor {
 		}
 		fifo = append(fifo, params...)
 		for _, elem := range params {
-			ntf := jsoff.NewNotifyMessage(
-				"fifo_subscription",
-				[]any{elem})
-			for _, session := range subs {
-				log.Infof("push to %s", session.SessionID())
-				session.Send(ntf)
+			for _, sink := range subs {
+				log.Infof("push to %s", sink.ID())
+				sink.Notify(elem)
 			}
 		}
 		return "ok", nil
@@ -89,22 +86,20 @@ func fifoActor() *jsoffnet.Actor {
 		return fifo[at], nil
 	}, jsoffnet.WithSchemaJson(`{"description": "get an element at index", "type": "method", "params": ["integer"]}`))
 
-	actor.OnRequest("fifo_subscribe", func(req *jsoffnet.RPCRequest, params []any) (any, error) {
-		session := req.Session()
-		if session == nil {
-			return "no sesion", nil
-		}
-		log.Infof("fifo_subscribe %s", session.SessionID())
-		if session == nil {
-			return nil, &jsoff.RPCError{Code: -400, Message: "no session established"}
-		}
-		subs[session.SessionID()] = session
-		return "ok", nil
-	})
-
-	actor.OnClose(func(session jsoffnet.RPCSession) {
-		log.Infof("fifo unsub %s", session.SessionID())
-		delete(subs, session.SessionID())
+	actor.OnSubscribe("fifo_subscribe", func(ctx context.Context, params []any, sink *jsoffnet.SubscriptionSink) error {
+		lock.Lock()
+		defer lock.Unlock()
+		log.Infof("fifo_subscribe %s", sink.ID())
+		subs[sink.ID()] = sink
+		go func() {
+			// unsubscribed or session closed
+			<-ctx.Done()
+			lock.Lock()
+			defer lock.Unlock()
+			log.Infof("fifo unsub %s", sink.ID())
+			delete(subs, sink.ID())
+		}()
+		return nil
 	})
 
 	return actor
@@ -0,0 +1,218 @@
+package jsoff
+
+// conversion hooks between JSON values and golang types, the decode
+// hooks are used by DecodeInterface, DecodeParams and typed
+// handlers, the encode hooks are applied to typed handler results.
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DecodeHook converts a JSON value into the registered type, the
+// hook returns data untouched if it cannot handle it.
+type DecodeHook func(data any) (any, error)
+
+// EncodeHook converts a value of the registered type into a value
+// ready to be JSON marshaled.
+type EncodeHook func(v any) (any, error)
+
+type hookRegistry struct {
+	lock        sync.RWMutex
+	decodeHooks map[reflect.Type]DecodeHook
+	encodeHooks map[reflect.Type]EncodeHook
+}
+
+var hooks = &hookRegistry{
+	decodeHooks: make(map[reflect.Type]DecodeHook),
+	encodeHooks: make(map[reflect.Type]EncodeHook),
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// RegisterDecodeHook registers a decode hook of type T, it replaces
+// the existing one. Hooks should be registered before the handlers
+// using them.
+func RegisterDecodeHook[T any](hook DecodeHook) {
+	tp := reflect.TypeOf((*T)(nil)).Elem()
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.decodeHooks[tp] = hook
+}
+
+// RegisterEncodeHook registers an encode hook of type T, it replaces
+// the existing one.
+func RegisterEncodeHook[T any](hook func(v T) (any, error)) {
+	tp := reflect.TypeOf((*T)(nil)).Elem()
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.encodeHooks[tp] = func(v any) (any, error) {
+		return hook(v.(T))
+	}
+}
+
+// HasDecodeHook returns whether values of tp are decoded by a hook
+func HasDecodeHook(tp reflect.Type) bool {
+	hooks.lock.RLock()
+	defer hooks.lock.RUnlock()
+	_, ok := hooks.decodeHooks[tp]
+	return ok
+}
+
+// EncodeValue converts v using the encode hook registered for its
+// type, v is returned untouched if there is no such hook.
+func EncodeValue(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	hooks.lock.RLock()
+	hook, ok := hooks.encodeHooks[reflect.TypeOf(v)]
+	hooks.lock.RUnlock()
+	if !ok {
+		return v, nil
+	}
+	return hook(v)
+}
+
+// decodeHook is the mapstructure hook dispatching to registered
+// hooks, or to the UnmarshalJSON/UnmarshalText methods of the target
+// type.
+func decodeHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if data == nil || from == to || to.Kind() == reflect.Interface {
+		return data, nil
+	}
+
+	hooks.lock.RLock()
+	hook, ok := hooks.decodeHooks[to]
+	hooks.lock.RUnlock()
+	if ok {
+		return hook(data)
+	}
+
+	ptrType := reflect.PointerTo(to)
+	if ptrType.Implements(jsonUnmarshalerType) {
+		marshaled, err := json.Marshal(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode hook")
+		}
+		output := reflect.New(to)
+		if err := output.Interface().(json.Unmarshaler).UnmarshalJSON(marshaled); err != nil {
+			return nil, err
+		}
+		return output.Elem().Interface(), nil
+	} else if ptrType.Implements(textUnmarshalerType) {
+		if s, ok := data.(string); ok {
+			output := reflect.New(to)
+			if err := output.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+				return nil, err
+			}
+			return output.Elem().Interface(), nil
+		}
+	}
+	return data, nil
+}
+
+// numberString returns the text of a JSON number
+func numberString(data any) (string, bool) {
+	switch v := data.(type) {
+	case json.Number:
+		return v.String(), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v), true
+	case float32, float64:
+		return fmt.Sprintf("%v", v), true
+	}
+	return "", false
+}
+
+func decodeBigInt(data any) (any, error) {
+	repr, ok := data.(string)
+	if !ok {
+		repr, ok = numberString(data)
+	}
+	if !ok {
+		return data, nil
+	}
+	bi, ok := new(big.Int).SetString(strings.TrimSpace(repr), 0)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("invalid big int %s", repr))
+	}
+	return *bi, nil
+}
+
+func init() {
+	// time.Time from a RFC3339 string or unix seconds
+	RegisterDecodeHook[time.Time](func(data any) (any, error) {
+		if s, ok := data.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+		if repr, ok := numberString(data); ok {
+			secs, err := json.Number(repr).Float64()
+			if err != nil {
+				return nil, err
+			}
+			return time.Unix(0, int64(secs*float64(time.Second))), nil
+		}
+		return data, nil
+	})
+
+	// time.Duration from a string like "1m30s" or nanoseconds
+	RegisterDecodeHook[time.Duration](func(data any) (any, error) {
+		if s, ok := data.(string); ok {
+			return time.ParseDuration(s)
+		}
+		if repr, ok := numberString(data); ok {
+			n, err := json.Number(repr).Int64()
+			if err != nil {
+				return nil, err
+			}
+			return time.Duration(n), nil
+		}
+		return data, nil
+	})
+
+	// []byte from a base64 string
+	RegisterDecodeHook[[]byte](func(data any) (any, error) {
+		if s, ok := data.(string); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+		return data, nil
+	})
+
+	// big.Int and Bigint from a decimal(or 0x prefixed) string or
+	// a number
+	RegisterDecodeHook[big.Int](decodeBigInt)
+	RegisterDecodeHook[Bigint](func(data any) (any, error) {
+		v, err := decodeBigInt(data)
+		if bi, ok := v.(big.Int); ok && err == nil {
+			return Bigint(bi), nil
+		}
+		return v, err
+	})
+
+	// json.Number from a number or a numeric string
+	RegisterDecodeHook[json.Number](func(data any) (any, error) {
+		if repr, ok := numberString(data); ok {
+			return json.Number(repr), nil
+		}
+		if s, ok := data.(string); ok {
+			if _, err := json.Number(s).Float64(); err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid number %s", s))
+			}
+			return json.Number(s), nil
+		}
+		return data, nil
+	})
+}
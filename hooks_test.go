@@ -0,0 +1,95 @@
+package jsoff
+
+import (
+	"encoding/json"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type hookTarget struct {
+	At      time.Time     `json:"at"`
+	Timeout time.Duration `json:"timeout"`
+	Data    []byte        `json:"data"`
+	Big     *big.Int      `json:"big"`
+	Bn      Bigint        `json:"bn"`
+	Num     json.Number   `json:"num"`
+	IP      net.IP        `json:"ip"`
+}
+
+func TestDefaultDecodeHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	var v hookTarget
+	err := DecodeInterface(map[string]any{
+		"at":      "2023-05-01T10:00:00Z",
+		"timeout": "1m30s",
+		"data":    "aGVsbG8=",
+		"big":     "123456789012345678901234567890",
+		"bn":      json.Number("987654321098765432109876543210"),
+		"num":     5,
+		"ip":      "10.0.0.1",
+	}, &v)
+	assert.Nil(err)
+	assert.Equal(time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), v.At.UTC())
+	assert.Equal(90*time.Second, v.Timeout)
+	assert.Equal([]byte("hello"), v.Data)
+	assert.Equal("123456789012345678901234567890", v.Big.String())
+	assert.Equal("987654321098765432109876543210", v.Bn.String())
+	assert.Equal(json.Number("5"), v.Num)
+	assert.Equal("10.0.0.1", v.IP.String())
+
+	var d time.Duration
+	err = DecodeInterface(json.Number("1000"), &d)
+	assert.Nil(err)
+	assert.Equal(time.Microsecond, d)
+
+	var bi *big.Int
+	err = DecodeInterface("not a number", &bi)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid big int")
+
+	var p struct {
+		At  time.Time
+		Big big.Int
+	}
+	err = DecodeParams([]any{"2023-05-01T10:00:00Z", "0xff"}, &p)
+	assert.Nil(err)
+	assert.Equal(2023, p.At.Year())
+	assert.Equal(int64(255), p.Big.Int64())
+}
+
+type upperString string
+
+func TestCustomHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	RegisterDecodeHook[upperString](func(data any) (any, error) {
+		if s, ok := data.(string); ok {
+			return upperString(strings.ToUpper(s)), nil
+		}
+		return data, nil
+	})
+	RegisterEncodeHook[upperString](func(v upperString) (any, error) {
+		return strings.ToLower(string(v)), nil
+	})
+	assert.True(HasDecodeHook(reflect.TypeOf(upperString(""))))
+
+	var u upperString
+	err := DecodeInterface("hello", &u)
+	assert.Nil(err)
+	assert.Equal(upperString("HELLO"), u)
+
+	ev, err := EncodeValue(u)
+	assert.Nil(err)
+	assert.Equal("hello", ev)
+
+	ev, err = EncodeValue(8)
+	assert.Nil(err)
+	assert.Equal(8, ev)
+}
@@ -105,6 +105,16 @@ func TestRequestMsg(t *testing.T) {
 	rpcErr := ParamsError("user issued")
 	assert.Equal(-32602, rpcErr.Code)
 	assert.Equal("user issued", rpcErr.Message)
+
+	// timeout extension
+	j2 := `{"id": 101, "method": "abc::add", "params": [], "timeout": 1500}`
+	msg2, err := ParseBytes([]byte(j2))
+	assert.Nil(err)
+	reqmsg2, _ := msg2.(*RequestMessage)
+	assert.Equal(int64(1500), reqmsg2.Timeout)
+	assert.Equal(int64(1500), reqmsg2.Clone(102).Timeout)
+	assert.Equal(`{"jsonrpc":"2.0","method":"abc::add","id":101,"params":[],"timeout":1500}`, MessageString(reqmsg2))
+	assert.Equal(int64(0), msg.(*RequestMessage).Timeout)
 }
 
 func TestNotifyMsg(t *testing.T) {
@@ -261,6 +261,7 @@ func (msg *RequestMessage) Interface() any {
 	tmp := &templateRequest{
 		Jsonrpc: "2.0",
 		TraceId: msg.TraceId(),
+		Timeout: msg.Timeout,
 		Method:  msg.Method,
 		Id:      msgIdT{Value: msg.Id, isSet: true},
 	}
@@ -333,6 +334,7 @@ func NewRequestMessage(id any, method string, params any) *RequestMessage {
 func (msg RequestMessage) Clone(newId any) *RequestMessage {
 	newReq := NewRequestMessage(newId, msg.Method, msg.Params)
 	newReq.SetTraceId(msg.traceId)
+	newReq.Timeout = msg.Timeout
 	return newReq
 }
 
@@ -50,6 +50,7 @@ type templateRequest struct {
 	Id      msgIdT `json:"id"`
 	Params  any    `json:"params"`
 	TraceId string `json:"traceid,omitempty"`
+	Timeout int64  `json:"timeout,omitempty"`
 }
 
 type templateNotify struct {
@@ -0,0 +1,415 @@
+package jsoffnet
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/superisaac/jsoff"
+)
+
+// BalanceStrategy decides which backend a call goes to
+type BalanceStrategy int
+
+const (
+	// backends take calls in turn
+	BalanceRoundRobin BalanceStrategy = iota
+	// the backend with the fewest pending calls takes the call
+	BalanceLeastPending
+	// calls with the same method and params go to the same backend,
+	// see RequestMessage.CacheKey
+	BalanceConsistentHash
+)
+
+type BalancedOptions struct {
+	// options of the backend clients
+	ClientOptions ClientOptions
+
+	Strategy BalanceStrategy
+
+	// a backend is ejected after so many consecutive failed calls,
+	// default 3
+	MaxFailures int
+
+	// how long an ejected backend stays out, default 10s
+	EjectTimeout time.Duration
+
+	// interval of the rpc.ping probes to every backend, the failed
+	// backends are ejected until they answer again. 0 disables the
+	// probes.
+	PingInterval time.Duration
+
+	// the virtual nodes of each backend on the hash ring, default 100
+	HashReplicas int
+
+	// send a hedged call to another backend if no reply arrives
+	// within HedgeDelay, see HedgedCall. 0 disables hedging.
+	HedgeDelay time.Duration
+
+	// the methods to hedge, which must be safe to call twice, "*"
+	// matches all methods
+	HedgeMethods []string
+
+	// stick the calls routed to streaming backends to one of them,
+	// so that the server side session, its values and subscriptions
+	// stay on one connection until the backend is ejected. The
+	// strategy then only picks the first backend.
+	StickyStreaming bool
+}
+
+type balancedBackend struct {
+	client  Client
+	pending atomic.Int64
+
+	// protected by the lock of BalancedClient
+	failures     int
+	ejectedUntil time.Time
+}
+
+type hashNode struct {
+	hash    uint32
+	backend *balancedBackend
+}
+
+// BalancedClient spreads calls over the clients of several backends,
+// which can be of any scheme, see BalancedOptions.StickyStreaming for
+// streaming backends.
+type BalancedClient struct {
+	options  BalancedOptions
+	backends []*balancedBackend
+	ring     []hashNode
+	next     atomic.Uint64
+
+	lock   sync.Mutex
+	sticky *balancedBackend
+
+	cancelPing func()
+}
+
+func NewBalancedClient(urls []string, opts BalancedOptions) (*BalancedClient, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no backend urls")
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 3
+	}
+	if opts.EjectTimeout <= 0 {
+		opts.EjectTimeout = 10 * time.Second
+	}
+	if opts.HashReplicas <= 0 {
+		opts.HashReplicas = 100
+	}
+	bc := &BalancedClient{options: opts}
+	for _, u := range urls {
+		c, err := NewClient(u, opts.ClientOptions)
+		if err != nil {
+			return nil, errors.Wrapf(err, "backend %s", u)
+		}
+		b := &balancedBackend{client: c}
+		bc.backends = append(bc.backends, b)
+		for i := 0; i < opts.HashReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", u, i)))
+			bc.ring = append(bc.ring, hashNode{hash: h, backend: b})
+		}
+	}
+	sort.Slice(bc.ring, func(i, j int) bool {
+		return bc.ring[i].hash < bc.ring[j].hash
+	})
+
+	if opts.PingInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		bc.cancelPing = cancel
+		go bc.pingLoop(ctx)
+	}
+	return bc, nil
+}
+
+// Clients returns the backend clients
+func (bc *BalancedClient) Clients() []Client {
+	clients := make([]Client, len(bc.backends))
+	for i, b := range bc.backends {
+		clients[i] = b.client
+	}
+	return clients
+}
+
+// Close stops the probes and closes the streaming backends
+func (bc *BalancedClient) Close() {
+	if bc.cancelPing != nil {
+		bc.cancelPing()
+	}
+	for _, b := range bc.backends {
+		if sc, ok := b.client.(interface{ Close() }); ok {
+			sc.Close()
+		}
+	}
+}
+
+// ServerURL returns the url of the first backend
+func (bc *BalancedClient) ServerURL() *url.URL {
+	return bc.backends[0].client.ServerURL()
+}
+
+func (bc *BalancedClient) SetClientTLSConfig(cfg *tls.Config) {
+	for _, b := range bc.backends {
+		b.client.SetClientTLSConfig(cfg)
+	}
+}
+
+func (bc *BalancedClient) SetExtraHeader(h http.Header) {
+	for _, b := range bc.backends {
+		b.client.SetExtraHeader(h)
+	}
+}
+
+// IsStreaming returns true if all backends are streaming
+func (bc *BalancedClient) IsStreaming() bool {
+	for _, b := range bc.backends {
+		if !b.client.IsStreaming() {
+			return false
+		}
+	}
+	return true
+}
+
+func (bc *BalancedClient) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	b := bc.pick(reqmsg)
+	if bc.options.HedgeDelay > 0 && bc.hedgeable(reqmsg.Method) {
+		if other := bc.pickOther(b); other != nil {
+			clients := []Client{
+				&backendCaller{Client: b.client, bc: bc, b: b},
+				&backendCaller{Client: other.client, bc: bc, b: other},
+			}
+			return HedgedCall(ctx, bc.options.HedgeDelay, clients, reqmsg)
+		}
+	}
+	return bc.callBackend(ctx, b, reqmsg)
+}
+
+func (bc *BalancedClient) callBackend(ctx context.Context, b *balancedBackend, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	b.pending.Add(1)
+	resmsg, err := b.client.Call(ctx, reqmsg)
+	b.pending.Add(-1)
+	bc.report(b, callFailed(resmsg, err))
+	return resmsg, err
+}
+
+func (bc *BalancedClient) hedgeable(method string) bool {
+	for _, m := range bc.options.HedgeMethods {
+		if m == "*" || m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// pickOther returns a healthy backend other than b, nil if there is
+// none
+func (bc *BalancedClient) pickOther(b *balancedBackend) *balancedBackend {
+	backends := bc.healthy()
+	start := int(bc.next.Add(1) % uint64(len(backends)))
+	for i := range backends {
+		if c := backends[(start+i)%len(backends)]; c != b {
+			return c
+		}
+	}
+	return nil
+}
+
+// backendCaller tracks the calls of a backend made by HedgedCall
+type backendCaller struct {
+	Client
+	bc *BalancedClient
+	b  *balancedBackend
+}
+
+func (c *backendCaller) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	return c.bc.callBackend(ctx, c.b, reqmsg)
+}
+
+func (bc *BalancedClient) UnwrapCall(ctx context.Context, reqmsg *jsoff.RequestMessage, output any) error {
+	resmsg, err := bc.Call(ctx, reqmsg)
+	if err != nil {
+		return err
+	}
+	if resmsg.IsResult() {
+		err := jsoff.DecodeInterface(resmsg.MustResult(), output)
+		if err != nil {
+			return errors.Wrapf(err, "RPC(%s)", reqmsg.Method)
+		}
+		return nil
+	} else {
+		return messageError(reqmsg, resmsg)
+	}
+}
+
+func (bc *BalancedClient) Send(ctx context.Context, msg jsoff.Message) error {
+	b := bc.pick(msg)
+	err := b.client.Send(ctx, msg)
+	bc.report(b, callFailed(nil, err))
+	return err
+}
+
+// healthy returns the backends not ejected, or all backends if every
+// backend is ejected
+func (bc *BalancedClient) healthy() []*balancedBackend {
+	now := time.Now()
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	backends := make([]*balancedBackend, 0, len(bc.backends))
+	for _, b := range bc.backends {
+		if !now.Before(b.ejectedUntil) {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == 0 {
+		return bc.backends
+	}
+	return backends
+}
+
+func (bc *BalancedClient) pick(msg jsoff.Message) *balancedBackend {
+	backends := bc.healthy()
+	var b *balancedBackend
+	switch bc.options.Strategy {
+	case BalanceConsistentHash:
+		// hashing gives affinity already, no need to stick
+		return bc.pickHash(hashKey(msg), backends)
+	case BalanceLeastPending:
+		start := int(bc.next.Add(1) % uint64(len(backends)))
+		for i := range backends {
+			c := backends[(start+i)%len(backends)]
+			if b == nil || c.pending.Load() < b.pending.Load() {
+				b = c
+			}
+		}
+	default:
+		b = backends[bc.next.Add(1)%uint64(len(backends))]
+	}
+	if !bc.options.StickyStreaming || !b.client.IsStreaming() {
+		return b
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if bc.sticky != nil {
+		for _, c := range backends {
+			if c == bc.sticky {
+				return c
+			}
+		}
+	}
+	bc.sticky = b
+	return b
+}
+
+// hashKey returns the key of msg on the hash ring, the method and
+// params of requests or the method of notifies
+func hashKey(msg jsoff.Message) string {
+	if reqmsg, ok := msg.(*jsoff.RequestMessage); ok {
+		return reqmsg.CacheKey("")
+	}
+	if msg.IsNotify() {
+		return msg.MustMethod()
+	}
+	return ""
+}
+
+func (bc *BalancedClient) pickHash(key string, backends []*balancedBackend) *balancedBackend {
+	alive := make(map[*balancedBackend]bool, len(backends))
+	for _, b := range backends {
+		alive[b] = true
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(bc.ring), func(i int) bool {
+		return bc.ring[i].hash >= h
+	})
+	for i := 0; i < len(bc.ring); i++ {
+		node := bc.ring[(start+i)%len(bc.ring)]
+		if alive[node.backend] {
+			return node.backend
+		}
+	}
+	return backends[0]
+}
+
+// report records the outcome of a call, the backend is ejected after
+// MaxFailures consecutive failures
+func (bc *BalancedClient) report(b *balancedBackend, failed bool) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= bc.options.MaxFailures {
+		b.failures = 0
+		bc.eject(b)
+	}
+}
+
+// eject must be called with lock held
+func (bc *BalancedClient) eject(b *balancedBackend) {
+	if time.Now().Before(b.ejectedUntil) {
+		return
+	}
+	log.Warnf("backend %s ejected", b.client.ServerURL())
+	b.ejectedUntil = time.Now().Add(bc.options.EjectTimeout)
+	if bc.sticky == b {
+		bc.sticky = nil
+	}
+}
+
+func (bc *BalancedClient) pingLoop(ctx context.Context) {
+	ticker := time.NewTicker(bc.options.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var wg sync.WaitGroup
+			for _, b := range bc.backends {
+				wg.Add(1)
+				go func(b *balancedBackend) {
+					defer wg.Done()
+					bc.ping(ctx, b)
+				}(b)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// ping probes the backend, a failed backend is ejected and an
+// answering one is put back at once
+func (bc *BalancedClient) ping(ctx context.Context, b *balancedBackend) {
+	pingCtx, cancel := context.WithTimeout(ctx, bc.options.PingInterval)
+	defer cancel()
+	reqmsg := jsoff.NewRequestMessage(jsoff.NewUuid(), PingMethod, nil)
+	resmsg, err := b.client.Call(pingCtx, reqmsg)
+	if ctx.Err() != nil {
+		return
+	}
+	failed := callFailed(resmsg, err)
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if failed {
+		bc.eject(b)
+	} else if !b.ejectedUntil.IsZero() {
+		log.Infof("backend %s is back", b.client.ServerURL())
+		b.failures = 0
+		b.ejectedUntil = time.Time{}
+	}
+}
@@ -0,0 +1,173 @@
+package jsoffnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+// starts a server answering its name to "whoami"
+func startNamedServer(ctx context.Context, name string, addr string) {
+	server := NewGatewayHandler(ctx, nil, true)
+	server.Actor.On("whoami", func(params []any) (any, error) {
+		return name, nil
+	})
+	go ListenAndServe(ctx, addr, server)
+}
+
+func TestBalancedRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startNamedServer(rootCtx, "a", "127.0.0.1:28011")
+	startNamedServer(rootCtx, "b", "127.0.0.1:28012")
+	time.Sleep(10 * time.Millisecond)
+
+	client, err := NewBalancedClient([]string{
+		"http://127.0.0.1:28011",
+		"http://127.0.0.1:28012",
+		// nothing listens on the port
+		"http://127.0.0.1:28013",
+	}, BalancedOptions{MaxFailures: 1})
+	assert.Nil(err)
+	defer client.Close()
+
+	names := map[any]int{}
+	failed := 0
+	for i := 0; i < 9; i++ {
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(i, "whoami", nil))
+		if err != nil {
+			failed++
+			continue
+		}
+		names[resmsg.MustResult()]++
+	}
+	// the dead backend is ejected after the first failure
+	assert.Equal(1, failed)
+	assert.Equal(8, names["a"]+names["b"])
+	assert.True(names["a"] >= 3 && names["b"] >= 3)
+
+	// the backends answer rpc.ping
+	resmsg, err := client.Clients()[0].Call(rootCtx, jsoff.NewRequestMessage(100, PingMethod, nil))
+	assert.Nil(err)
+	assert.Equal("pong", resmsg.MustResult())
+}
+
+func TestBalancedConsistentHash(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startNamedServer(rootCtx, "a", "127.0.0.1:28014")
+	startNamedServer(rootCtx, "b", "127.0.0.1:28015")
+	time.Sleep(10 * time.Millisecond)
+
+	client, err := NewBalancedClient([]string{
+		"http://127.0.0.1:28014",
+		"http://127.0.0.1:28015",
+		"http://127.0.0.1:28016",
+	}, BalancedOptions{
+		Strategy:     BalanceConsistentHash,
+		PingInterval: 20 * time.Millisecond,
+	})
+	assert.Nil(err)
+	defer client.Close()
+
+	// the probes eject the dead backend
+	time.Sleep(60 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		params := []any{fmt.Sprintf("key%d", i)}
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "whoami", params))
+		assert.Nil(err)
+		name := resmsg.MustResult()
+		for j := 0; j < 3; j++ {
+			resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(2, "whoami", params))
+			assert.Nil(err)
+			assert.Equal(name, resmsg.MustResult())
+		}
+	}
+}
+
+func TestBalancedStickyStreaming(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startNamedServer(rootCtx, "a", "127.0.0.1:28017")
+	startNamedServer(rootCtx, "b", "127.0.0.1:28018")
+	time.Sleep(10 * time.Millisecond)
+
+	client, err := NewBalancedClient([]string{
+		"ws://127.0.0.1:28017",
+		"ws://127.0.0.1:28018",
+	}, BalancedOptions{Strategy: BalanceLeastPending, StickyStreaming: true})
+	assert.Nil(err)
+	defer client.Close()
+	assert.True(client.IsStreaming())
+
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "whoami", nil))
+	assert.Nil(err)
+	name := resmsg.MustResult()
+	for i := 0; i < 5; i++ {
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(i, "whoami", nil))
+		assert.Nil(err)
+		assert.Equal(name, resmsg.MustResult())
+	}
+}
+
+func TestBalancedStreamingSpread(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startNamedServer(rootCtx, "a", "127.0.0.1:28032")
+	startNamedServer(rootCtx, "b", "127.0.0.1:28033")
+	time.Sleep(10 * time.Millisecond)
+
+	// streaming backends take calls in turn unless sticky
+	client, err := NewBalancedClient([]string{
+		"ws://127.0.0.1:28032",
+		"ws://127.0.0.1:28033",
+	}, BalancedOptions{Strategy: BalanceRoundRobin})
+	assert.Nil(err)
+	defer client.Close()
+
+	names := map[any]int{}
+	for i := 0; i < 6; i++ {
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(i, "whoami", nil))
+		assert.Nil(err)
+		names[resmsg.MustResult()]++
+	}
+	assert.Equal(map[any]int{"a": 3, "b": 3}, names)
+}
+
+func TestPingMissingHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	ping := func(actor *Actor) jsoff.Message {
+		req := NewRPCRequest(ctx, jsoff.NewRequestMessage(1, PingMethod, nil), TransportHTTP)
+		resmsg, err := actor.Feed(req)
+		assert.Nil(err)
+		return resmsg
+	}
+
+	assert.Equal("pong", ping(NewActor()).MustResult())
+
+	// the missing handler of a proxy answers rpc.ping
+	proxy := NewActor()
+	proxy.OnMissing(func(req *RPCRequest) (any, error) {
+		return "upstream " + req.Msg().MustMethod(), nil
+	})
+	assert.Equal("upstream rpc.ping", ping(proxy).MustResult())
+}
@@ -0,0 +1,292 @@
+package jsoffnet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superisaac/jsoff"
+)
+
+// ErrCircuitOpen is returned without calling the server when the
+// circuit of the server is open
+var ErrCircuitOpen = errors.New("circuit open")
+
+type CircuitState int
+
+const (
+	// calls pass through
+	CircuitClosed CircuitState = iota
+	// calls fail with ErrCircuitOpen
+	CircuitOpen
+	// a probing call is let through to decide whether to close
+	// the circuit again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitChangeHandler is called when the circuit of key changes state
+type CircuitChangeHandler func(key string, from, to CircuitState)
+
+type BreakerOptions struct {
+	// open the circuit after so many consecutive failures, 0
+	// disables the threshold
+	ConsecutiveFailures int
+
+	// open the circuit when the failure rate within Window
+	// reaches FailureRate, 0 disables the threshold
+	FailureRate float64
+
+	// the minimum number of calls within Window before FailureRate
+	// applies, default 10
+	MinRequests int
+
+	// the window to count the failure rate, default 60s
+	Window time.Duration
+
+	// how long the circuit stays open before a probing call is let
+	// through, default 30s
+	OpenTimeout time.Duration
+
+	// keep a circuit per method of each server
+	PerMethod bool
+
+	// called when a circuit changes state, the handler is called
+	// without holding locks
+	OnStateChange CircuitChangeHandler
+}
+
+type circuit struct {
+	state       CircuitState
+	consecutive int
+	// counts of the current window
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// CircuitBreaker keeps circuits keyed by server url and optionally
+// method, it can be shared by clients of different servers.
+type CircuitBreaker struct {
+	options  BreakerOptions
+	lock     sync.Mutex
+	circuits map[string]*circuit
+}
+
+func NewCircuitBreaker(opts BreakerOptions) *CircuitBreaker {
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10
+	}
+	if opts.Window <= 0 {
+		opts.Window = 60 * time.Second
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	return &CircuitBreaker{
+		options:  opts,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// Wrap returns a client whose calls go through the breaker
+func (b *CircuitBreaker) Wrap(client Client) Client {
+	return &breakerClient{Client: client, breaker: b}
+}
+
+// Key returns the key of the circuit of the server and method
+func (b *CircuitBreaker) Key(serverUrl string, method string) string {
+	if b.options.PerMethod && method != "" {
+		return serverUrl + " " + method
+	}
+	return serverUrl
+}
+
+// State returns the state of the circuit of key
+func (b *CircuitBreaker) State(key string) CircuitState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if c, ok := b.circuits[key]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+func (b *CircuitBreaker) circuit(key string) *circuit {
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.circuits[key] = c
+	}
+	return c
+}
+
+// allow returns whether a call of key can be made
+func (b *CircuitBreaker) allow(key string) bool {
+	b.lock.Lock()
+	c := b.circuit(key)
+	from := c.state
+	allowed := true
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.openedAt) < b.options.OpenTimeout {
+			allowed = false
+		} else {
+			// this call is the probe
+			c.state = CircuitHalfOpen
+			c.probing = true
+		}
+	case CircuitHalfOpen:
+		if c.probing {
+			allowed = false
+		} else {
+			c.probing = true
+		}
+	}
+	to := c.state
+	b.lock.Unlock()
+	b.changed(key, from, to)
+	return allowed
+}
+
+// settle records the outcome of a call of key, a call cancelled by
+// the caller tells nothing about the server so it is not counted, and
+// a cancelled probe leaves the circuit half open for the next probe
+func (b *CircuitBreaker) settle(key string, resmsg jsoff.Message, err error) {
+	if !errors.Is(err, context.Canceled) {
+		b.report(key, callFailed(resmsg, err))
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if c := b.circuit(key); c.state == CircuitHalfOpen {
+		c.probing = false
+	}
+}
+
+// report records the outcome of a call of key
+func (b *CircuitBreaker) report(key string, failed bool) {
+	b.lock.Lock()
+	c := b.circuit(key)
+	from := c.state
+	now := time.Now()
+	switch c.state {
+	case CircuitHalfOpen:
+		c.probing = false
+		if failed {
+			c.state = CircuitOpen
+			c.openedAt = now
+		} else {
+			c.state = CircuitClosed
+			c.consecutive = 0
+			c.windowStart = now
+			c.total = 0
+			c.failures = 0
+		}
+	case CircuitClosed:
+		if now.Sub(c.windowStart) > b.options.Window {
+			c.windowStart = now
+			c.total = 0
+			c.failures = 0
+		}
+		c.total++
+		if failed {
+			c.failures++
+			c.consecutive++
+		} else {
+			c.consecutive = 0
+		}
+		if b.tripped(c) {
+			c.state = CircuitOpen
+			c.openedAt = now
+		}
+	}
+	to := c.state
+	b.lock.Unlock()
+	b.changed(key, from, to)
+}
+
+func (b *CircuitBreaker) tripped(c *circuit) bool {
+	opts := b.options
+	if opts.ConsecutiveFailures > 0 && c.consecutive >= opts.ConsecutiveFailures {
+		return true
+	}
+	if opts.FailureRate > 0 && c.total >= opts.MinRequests {
+		return float64(c.failures)/float64(c.total) >= opts.FailureRate
+	}
+	return false
+}
+
+func (b *CircuitBreaker) changed(key string, from, to CircuitState) {
+	if from != to && b.options.OnStateChange != nil {
+		b.options.OnStateChange(key, from, to)
+	}
+}
+
+// callFailed returns whether the outcome of a call means the server
+// is unhealthy, error messages other than timeout are answers of a
+// healthy server
+func callFailed(resmsg jsoff.Message, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resmsg != nil && resmsg.IsError() && resmsg.MustError().Code == jsoff.ErrTimeout.Code
+}
+
+type breakerClient struct {
+	Client
+	breaker *CircuitBreaker
+}
+
+func (c *breakerClient) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	key := c.breaker.Key(c.ServerURL().String(), reqmsg.Method)
+	if !c.breaker.allow(key) {
+		return nil, errors.Wrapf(ErrCircuitOpen, "RPC(%s)", reqmsg.Method)
+	}
+	resmsg, err := c.Client.Call(ctx, reqmsg)
+	c.breaker.settle(key, resmsg, err)
+	return resmsg, err
+}
+
+func (c *breakerClient) UnwrapCall(ctx context.Context, reqmsg *jsoff.RequestMessage, output any) error {
+	resmsg, err := c.Call(ctx, reqmsg)
+	if err != nil {
+		return err
+	}
+	if resmsg.IsResult() {
+		err := jsoff.DecodeInterface(resmsg.MustResult(), output)
+		if err != nil {
+			return errors.Wrapf(err, "RPC(%s)", reqmsg.Method)
+		}
+		return nil
+	} else {
+		return messageError(reqmsg, resmsg)
+	}
+}
+
+func (c *breakerClient) Send(ctx context.Context, msg jsoff.Message) error {
+	method := ""
+	if msg.IsRequest() || msg.IsNotify() {
+		method = msg.MustMethod()
+	}
+	key := c.breaker.Key(c.ServerURL().String(), method)
+	if !c.breaker.allow(key) {
+		return ErrCircuitOpen
+	}
+	err := c.Client.Send(ctx, msg)
+	c.breaker.settle(key, nil, err)
+	return err
+}
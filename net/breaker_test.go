@@ -0,0 +1,180 @@
+package jsoffnet
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+// a client returning the error it is told to
+type fakeClient struct {
+	serverUrl *url.URL
+	err       error
+	// answered as an Error message if set
+	errbody *jsoff.RPCError
+	calls   int
+}
+
+func (c *fakeClient) ServerURL() *url.URL {
+	return c.serverUrl
+}
+
+func (c *fakeClient) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.errbody != nil {
+		return c.errbody.ToMessage(reqmsg), nil
+	}
+	return jsoff.NewResultMessage(reqmsg, "ok"), nil
+}
+
+func (c *fakeClient) UnwrapCall(ctx context.Context, reqmsg *jsoff.RequestMessage, output any) error {
+	return nil
+}
+
+func (c *fakeClient) Send(ctx context.Context, msg jsoff.Message) error {
+	return c.err
+}
+
+func (c *fakeClient) SetClientTLSConfig(cfg *tls.Config) {}
+
+func (c *fakeClient) SetExtraHeader(h http.Header) {}
+
+func (c *fakeClient) IsStreaming() bool {
+	return false
+}
+
+func TestCircuitBreakerConsecutive(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	changes := make(chan CircuitState, 10)
+	breaker := NewCircuitBreaker(BreakerOptions{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         50 * time.Millisecond,
+		OnStateChange: func(key string, from, to CircuitState) {
+			assert.Equal("http://127.0.0.1:1", key)
+			changes <- to
+		},
+	})
+	upstream := &fakeClient{serverUrl: urlParse("http://127.0.0.1:1"), err: TransportConnectFailed}
+	client := breaker.Wrap(upstream)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Call(ctx, jsoff.NewRequestMessage(i, "echo", nil))
+		assert.ErrorIs(err, TransportConnectFailed)
+	}
+	assert.Equal(CircuitOpen, <-changes)
+
+	// fail fast without calling upstream
+	_, err := client.Call(ctx, jsoff.NewRequestMessage(4, "echo", nil))
+	assert.ErrorIs(err, ErrCircuitOpen)
+	assert.Equal(3, upstream.calls)
+
+	// the probe fails and the circuit opens again
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.Call(ctx, jsoff.NewRequestMessage(5, "echo", nil))
+	assert.ErrorIs(err, TransportConnectFailed)
+	assert.Equal(CircuitHalfOpen, <-changes)
+	assert.Equal(CircuitOpen, <-changes)
+
+	// the probe succeeds and the circuit closes
+	upstream.err = nil
+	time.Sleep(60 * time.Millisecond)
+	resmsg, err := client.Call(ctx, jsoff.NewRequestMessage(6, "echo", nil))
+	assert.Nil(err)
+	assert.Equal("ok", resmsg.MustResult())
+	assert.Equal(CircuitHalfOpen, <-changes)
+	assert.Equal(CircuitClosed, <-changes)
+	assert.Equal(CircuitClosed, breaker.State("http://127.0.0.1:1"))
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	breaker := NewCircuitBreaker(BreakerOptions{
+		FailureRate: 0.5,
+		MinRequests: 4,
+		PerMethod:   true,
+	})
+	upstream := &fakeClient{serverUrl: urlParse("http://127.0.0.1:1")}
+	client := breaker.Wrap(upstream)
+
+	for i := 0; i < 4; i++ {
+		if i%2 == 0 {
+			upstream.err = errors.New("bad gateway")
+		} else {
+			upstream.err = nil
+		}
+		client.Call(ctx, jsoff.NewRequestMessage(i, "query", nil))
+	}
+	assert.Equal(CircuitOpen, breaker.State(breaker.Key("http://127.0.0.1:1", "query")))
+
+	// other methods have their own circuits
+	upstream.err = nil
+	_, err := client.Call(ctx, jsoff.NewRequestMessage(5, "query", nil))
+	assert.True(errors.Is(err, ErrCircuitOpen))
+	_, err = client.Call(ctx, jsoff.NewRequestMessage(6, "status", nil))
+	assert.Nil(err)
+}
+
+func TestCircuitBreakerUnwrapCall(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	breaker := NewCircuitBreaker(BreakerOptions{ConsecutiveFailures: 3})
+	upstream := &fakeClient{serverUrl: urlParse("http://127.0.0.1:1"), errbody: jsoff.ErrMethodNotFound}
+	client := breaker.Wrap(upstream)
+
+	var res string
+	err := client.UnwrapCall(ctx, jsoff.NewRequestMessage(1, "echo", nil), &res)
+	var rpcErr *RPCError
+	assert.True(errors.As(err, &rpcErr))
+	assert.Equal("echo", rpcErr.Method)
+	assert.False(IsRetryable(err))
+
+	upstream.errbody = jsoff.ErrTimeout
+	err = client.UnwrapCall(ctx, jsoff.NewRequestMessage(2, "echo", nil), &res)
+	var timeoutErr *TimeoutError
+	assert.True(errors.As(err, &timeoutErr))
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	breaker := NewCircuitBreaker(BreakerOptions{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         50 * time.Millisecond,
+	})
+	upstream := &fakeClient{serverUrl: urlParse("http://127.0.0.1:1"), err: TransportConnectFailed}
+	client := breaker.Wrap(upstream)
+	key := "http://127.0.0.1:1"
+
+	client.Call(ctx, jsoff.NewRequestMessage(1, "echo", nil))
+	assert.Equal(CircuitOpen, breaker.State(key))
+
+	// the probe is cancelled by the caller, the circuit stays half
+	// open and lets the next probe through
+	time.Sleep(60 * time.Millisecond)
+	upstream.err = context.Canceled
+	_, err := client.Call(ctx, jsoff.NewRequestMessage(2, "echo", nil))
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(CircuitHalfOpen, breaker.State(key))
+
+	upstream.err = TransportConnectFailed
+	_, err = client.Call(ctx, jsoff.NewRequestMessage(3, "echo", nil))
+	assert.ErrorIs(err, TransportConnectFailed)
+	assert.Equal(CircuitOpen, breaker.State(key))
+	assert.Equal(3, upstream.calls)
+}
@@ -6,30 +6,22 @@ import (
 )
 
 // NewClient returns an JSONRPC client whose type depends on the
-// server url it wants to connect to. Currently there are 3 types of
-// supported url schemes: the HTTP/1.1 client, the websocket based
-// client and HTTP2 base client, the latter two types are streaming
-// clients which can accept server push messages.
+// server url it wants to connect to, see RegisterClientScheme. The
+// built-in schemes are http(s) for the HTTP/1.1 client, ws(s), h2(c),
+// tcp and vsock for the streaming clients which can accept server
+// push messages.
 func NewClient(serverUrl string, optlist ...ClientOptions) (Client, error) {
 	u, err := url.Parse(serverUrl)
 	if err != nil {
 		return nil, errors.Wrap(err, "url.Parse")
 	}
-	switch u.Scheme {
-	case "http", "https":
-		// HTTP/1.1 client
-		return NewHttp1Client(u, optlist...), nil
-	case "ws", "wss":
-		// Websocket client
-		return NewWSClient(u), nil
-	case "h2", "h2c":
-		// HTTP2 client
-		return NewHttp2Client(u), nil
-	case "tcp":
-		return NewTCPClient(u), nil
-	case "vsock":
-		return NewVsockClient(u), nil
-	default:
-		return nil, errors.New("url scheme not supported")
+	factory, ok := clientFactory(u.Scheme)
+	if !ok {
+		return nil, errUnsupported
 	}
+	opts := ClientOptions{}
+	if len(optlist) > 0 {
+		opts = optlist[0]
+	}
+	return factory(u, opts)
 }
@@ -1,8 +1,15 @@
 package jsoffnet
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/superisaac/jsoff"
 )
 
 // errors
@@ -25,3 +32,178 @@ type SimpleResponse struct {
 func (resp SimpleResponse) Error() string {
 	return fmt.Sprintf("%d/%s", resp.Code, resp.Body)
 }
+
+// The errors returned by the clients are wrapped into the types
+// below, so that callers can tell the failures apart with errors.As
+// regardless of the transport:
+//
+//   - TransportError, the connection failed or dropped
+//   - TimeoutError, the call did not finish in time
+//   - HTTPStatusError, the http server answered a non 200 status
+//   - RPCError, the server answered an Error message, returned by
+//     UnwrapCall only as Call returns Error messages as they are
+//
+// The original errors, e.g. TransportClosed, *WrappedResponse and
+// *jsoff.RPCError, are kept in the chain so errors.Is and errors.As
+// still find them.
+
+// RetryableError tells whether the failed call is worth calling
+// again, all the error types above implement it
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+// IsRetryable returns whether err is a RetryableError saying so
+func IsRetryable(err error) bool {
+	var r RetryableError
+	return errors.As(err, &r) && r.Retryable()
+}
+
+// TransportError is a failure of the underlying connection
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("transport error, %s", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns true if the connect is refused or the connection
+// drops
+func (e *TransportError) Retryable() bool {
+	return errors.Is(e.Err, TransportConnectFailed) ||
+		errors.Is(e.Err, TransportClosed) ||
+		errors.Is(e.Err, syscall.ECONNREFUSED) ||
+		errors.Is(e.Err, syscall.ECONNRESET) ||
+		errors.Is(e.Err, io.EOF) ||
+		errors.Is(e.Err, io.ErrUnexpectedEOF)
+}
+
+// TimeoutError is a call not finished in time, either timed out by
+// the client or by the server
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout, %s", e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns false if the deadline of the caller exceeds, as
+// another attempt will not make it either
+func (e *TimeoutError) Retryable() bool {
+	return !errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// HTTPStatusError is a non 200 response of the http server
+type HTTPStatusError struct {
+	StatusCode int
+	Body       []byte
+
+	// the original error, *WrappedResponse or *SimpleResponse,
+	// which handlers may pass through
+	Err error
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("http status %d", e.StatusCode)
+}
+
+func (e *HTTPStatusError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns true for the statuses 429, 502 and 503
+func (e *HTTPStatusError) Retryable() bool {
+	for _, status := range defaultRetryableStatus {
+		if e.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// RPCError is an Error message answered by the server
+type RPCError struct {
+	Method string
+	Err    *jsoff.RPCError
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("RPC(%s) %s", e.Method, e.Err.Error())
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns false, the server has handled the request
+func (e *RPCError) Retryable() bool {
+	return false
+}
+
+// messageError returns the error of an Error message, a timeout
+// Error message is a TimeoutError
+func messageError(reqmsg *jsoff.RequestMessage, resmsg jsoff.Message) error {
+	rpcErr := &RPCError{Method: reqmsg.Method, Err: resmsg.MustError()}
+	if rpcErr.Err.Code == jsoff.ErrTimeout.Code {
+		return &TimeoutError{Err: rpcErr}
+	}
+	return rpcErr
+}
+
+// classifyError wraps the errors of a call into the error types
+// above, the context cancellation and the unknown errors are
+// returned as they are
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var typed RetryableError
+	if errors.As(err, &typed) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{Err: err}
+	}
+	var simpleResp *SimpleResponse
+	if errors.As(err, &simpleResp) {
+		if simpleResp.Code == http.StatusRequestTimeout {
+			return &TimeoutError{Err: err}
+		}
+		return &HTTPStatusError{StatusCode: simpleResp.Code, Body: simpleResp.Body, Err: err}
+	}
+	var wrappedResp *WrappedResponse
+	if errors.As(err, &wrappedResp) {
+		statusErr := &HTTPStatusError{Body: wrappedResp.Body, Err: err}
+		if wrappedResp.Response != nil {
+			statusErr.StatusCode = wrappedResp.Response.StatusCode
+		}
+		return statusErr
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &TimeoutError{Err: err}
+	}
+	if errors.Is(err, TransportConnectFailed) ||
+		errors.Is(err, TransportClosed) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return &TransportError{Err: err}
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return &TransportError{Err: err}
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package jsoffnet
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+func TestClassifyError(t *testing.T) {
+	assert := assert.New(t)
+
+	var transportErr *TransportError
+	err := classifyError(errors.Wrap(TransportClosed, "read"))
+	assert.True(errors.As(err, &transportErr))
+	assert.ErrorIs(err, TransportClosed)
+	assert.True(IsRetryable(err))
+
+	var timeoutErr *TimeoutError
+	err = classifyError(&SimpleResponse{Code: http.StatusRequestTimeout})
+	assert.True(errors.As(err, &timeoutErr))
+	assert.True(IsRetryable(err))
+
+	// the deadline of the caller exceeds
+	err = classifyError(context.DeadlineExceeded)
+	assert.True(errors.As(err, &timeoutErr))
+	assert.False(IsRetryable(err))
+
+	err = classifyError(context.Canceled)
+	assert.Equal(context.Canceled, err)
+	assert.False(IsRetryable(err))
+
+	// typed errors are kept
+	statusErr := &HTTPStatusError{StatusCode: http.StatusBadGateway}
+	assert.Equal(statusErr, classifyError(statusErr))
+	assert.True(IsRetryable(statusErr))
+	assert.False(IsRetryable(&HTTPStatusError{StatusCode: http.StatusBadRequest}))
+
+	unknown := errors.New("unknown")
+	assert.Equal(unknown, classifyError(unknown))
+	assert.False(IsRetryable(unknown))
+}
+
+func TestClientErrorTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewGatewayHandler(rootCtx, nil, true)
+	server.Actor.On("slow", func(params []any) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return "ok", nil
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.Handle("/", server)
+	go ListenAndServe(rootCtx, "127.0.0.1:28027", mux)
+	time.Sleep(10 * time.Millisecond)
+
+	// nothing listens on the port
+	for _, serverUrl := range []string{"http://127.0.0.1:28028", "ws://127.0.0.1:28028", "tcp://127.0.0.1:28028"} {
+		client, err := NewClient(serverUrl)
+		assert.Nil(err)
+		_, err = client.Call(rootCtx, jsoff.NewRequestMessage(1, "slow", nil))
+		var transportErr *TransportError
+		assert.True(errors.As(err, &transportErr), serverUrl)
+		assert.True(IsRetryable(err), serverUrl)
+	}
+
+	client, err := NewClient("http://127.0.0.1:28027/unavailable")
+	assert.Nil(err)
+	_, err = client.Call(rootCtx, jsoff.NewRequestMessage(1, "slow", nil))
+	var statusErr *HTTPStatusError
+	assert.True(errors.As(err, &statusErr))
+	assert.Equal(http.StatusServiceUnavailable, statusErr.StatusCode)
+	assert.True(IsRetryable(err))
+	// the original error is kept
+	var wrappedResp *WrappedResponse
+	assert.True(errors.As(err, &wrappedResp))
+
+	for _, serverUrl := range []string{"http://127.0.0.1:28027", "ws://127.0.0.1:28027"} {
+		client, err := NewClient(serverUrl)
+		assert.Nil(err)
+
+		var res string
+		err = client.UnwrapCall(rootCtx, jsoff.NewRequestMessage(2, "nosuchmethod", nil), &res)
+		var rpcErr *RPCError
+		assert.True(errors.As(err, &rpcErr), serverUrl)
+		assert.Equal("nosuchmethod", rpcErr.Method)
+		assert.False(IsRetryable(err))
+		var errbody *jsoff.RPCError
+		assert.True(errors.As(err, &errbody))
+		assert.Equal(jsoff.ErrMethodNotFound.Code, errbody.Code)
+
+		ctx, cancelCall := context.WithTimeout(rootCtx, 50*time.Millisecond)
+		err = client.UnwrapCall(ctx, jsoff.NewRequestMessage(3, "slow", nil), &res)
+		cancelCall()
+		var timeoutErr *TimeoutError
+		assert.True(errors.As(err, &timeoutErr), serverUrl)
+	}
+}
@@ -2,7 +2,11 @@ package jsoffnet
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 )
 
 // shared handler serve http1/http2/websocket server over the same port
@@ -11,10 +15,30 @@ import (
 // NOTE: gateway handler must work over TLS to serve h2
 type GatewayHandler struct {
 	h1Handler http.Handler
-	wsHandler http.Handler
+	wsHandler *WSHandler
 	h2Handler http.Handler
+	h2Server  *Http2Handler
 	Actor     *Actor
 	insecure  bool
+
+	// the live sessions of websocket and http2 handlers
+	Sessions *SessionRegistry
+}
+
+func init() {
+	serve := func(ctx context.Context, bindUrl *url.URL, actor *Actor, tlsConfig *TLSConfig) error {
+		switch bindUrl.Scheme {
+		case "https", "wss", "h2":
+			if tlsConfig == nil {
+				return fmt.Errorf("scheme %s requires tls config", bindUrl.Scheme)
+			}
+		}
+		handler := NewGatewayHandler(ctx, actor, tlsConfig == nil)
+		return ListenAndServe(ctx, bindUrl.Host, handler, tlsConfig)
+	}
+	for _, scheme := range []string{"http", "https", "ws", "wss", "h2", "h2c"} {
+		RegisterServerScheme(scheme, serve)
+	}
 }
 
 func NewGatewayHandler(serverCtx context.Context, actor *Actor, insecure bool) *GatewayHandler {
@@ -22,21 +46,51 @@ func NewGatewayHandler(serverCtx context.Context, actor *Actor, insecure bool) *
 		actor = NewActor()
 	}
 
+	sessions := NewSessionRegistry()
+	wsHandler := NewWSHandler(serverCtx, actor)
+	wsHandler.Sessions = sessions
+	h2Handler := NewHttp2Handler(serverCtx, actor)
+	h2Handler.Sessions = sessions
+
 	sh := &GatewayHandler{
 		Actor:     actor,
 		h1Handler: NewHttp1Handler(actor),
-		wsHandler: NewWSHandler(serverCtx, actor),
+		wsHandler: wsHandler,
 		insecure:  insecure,
+		Sessions:  sessions,
+		h2Server:  h2Handler,
 	}
 
 	if insecure {
-		sh.h2Handler = NewHttp2Handler(serverCtx, actor).Http2CHandler()
+		sh.h2Handler = h2Handler.Http2CHandler()
 	} else {
-		sh.h2Handler = NewHttp2Handler(serverCtx, actor)
+		sh.h2Handler = h2Handler
 	}
 	return sh
 }
 
+// Shutdown gracefully shuts down the websocket and http2 sessions,
+// both stop accepting new sessions before the live ones are drained
+// concurrently
+func (handler *GatewayHandler) Shutdown(ctx context.Context) error {
+	handler.wsHandler.shuttingDown.Store(true)
+	handler.h2Server.shuttingDown.Store(true)
+
+	var wg sync.WaitGroup
+	var wsErr, h2Err error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		wsErr = handler.wsHandler.Shutdown(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		h2Err = handler.h2Server.Shutdown(ctx)
+	}()
+	wg.Wait()
+	return errors.Join(wsErr, h2Err)
+}
+
 func (handler *GatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.ProtoAtLeast(2, 0) {
 		// http2 check by proto
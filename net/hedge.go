@@ -0,0 +1,108 @@
+package jsoffnet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superisaac/jsoff"
+)
+
+// HedgedCall calls reqmsg on the first client, and if no reply
+// arrives within delay, or the call fails, calls a clone of reqmsg on
+// the next client and so on. The first successful reply wins and the
+// other calls are cancelled. The clones carry nil ids so that the
+// clients assign them, see Client.Call, the replies take the id of
+// reqmsg.
+func HedgedCall(ctx context.Context, delay time.Duration, clients []Client, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("no clients")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		resmsg jsoff.Message
+		err    error
+	}
+	// clients may modify the request, e.g. the trace id, so every
+	// call takes a clone made before any call starts
+	msgs := make([]*jsoff.RequestMessage, len(clients))
+	msgs[0] = reqmsg.Clone(reqmsg.Id)
+	for i := 1; i < len(clients); i++ {
+		msgs[i] = reqmsg.Clone(nil)
+	}
+	outcomes := make(chan outcome, len(clients))
+	launched := 0
+	launch := func() {
+		client, msg := clients[launched], msgs[launched]
+		launched++
+		go func() {
+			resmsg, err := client.Call(ctx, msg)
+			if resmsg != nil {
+				resmsg = resmsg.ReplaceId(reqmsg.Id)
+			}
+			outcomes <- outcome{resmsg, err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var last outcome
+	for received := 0; received < launched; {
+		select {
+		case <-timer.C:
+			if launched < len(clients) {
+				launch()
+				timer.Reset(delay)
+			}
+		case o := <-outcomes:
+			received++
+			if o.err == nil {
+				return o.resmsg, nil
+			}
+			last = o
+			// hedge at once when a call fails
+			if launched < len(clients) {
+				launch()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return last.resmsg, last.err
+}
+
+// FanOutResult is the outcome of calling one of the clients
+type FanOutResult struct {
+	Client Client
+	Result jsoff.Message
+	Err    error
+}
+
+// FanOut calls reqmsg on all clients concurrently and returns the
+// results in the order of clients, e.g. for quorum checks. Each call
+// takes a clone of reqmsg with a nil id which the client assigns,
+// see Client.Call, the replies take the id of reqmsg.
+func FanOut(ctx context.Context, clients []Client, reqmsg *jsoff.RequestMessage) []FanOutResult {
+	msgs := make([]*jsoff.RequestMessage, len(clients))
+	for i := range clients {
+		msgs[i] = reqmsg.Clone(nil)
+	}
+	results := make([]FanOutResult, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client Client) {
+			defer wg.Done()
+			resmsg, err := client.Call(ctx, msgs[i])
+			if resmsg != nil {
+				resmsg = resmsg.ReplaceId(reqmsg.Id)
+			}
+			results[i] = FanOutResult{Client: client, Result: resmsg, Err: err}
+		}(i, client)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,139 @@
+package jsoffnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+func mustClient(t *testing.T, serverUrl string) Client {
+	c, err := NewClient(serverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHedgedCall(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := NewGatewayHandler(rootCtx, nil, true)
+	slow.Actor.On("whoami", func(params []any) (any, error) {
+		time.Sleep(500 * time.Millisecond)
+		return "slow", nil
+	})
+	go ListenAndServe(rootCtx, "127.0.0.1:28019", slow)
+	startNamedServer(rootCtx, "fast", "127.0.0.1:28020")
+	time.Sleep(10 * time.Millisecond)
+
+	clients := []Client{
+		mustClient(t, "http://127.0.0.1:28019"),
+		mustClient(t, "http://127.0.0.1:28020"),
+	}
+
+	start := time.Now()
+	resmsg, err := HedgedCall(rootCtx, 50*time.Millisecond, clients, jsoff.NewRequestMessage(1, "whoami", nil))
+	assert.Nil(err)
+	assert.Equal("fast", resmsg.MustResult())
+	assert.Equal(1, resmsg.MustId())
+	assert.True(time.Since(start) < 400*time.Millisecond)
+
+	// a failed call is hedged at once
+	clients = []Client{
+		mustClient(t, "http://127.0.0.1:28021"),
+		mustClient(t, "http://127.0.0.1:28020"),
+	}
+	start = time.Now()
+	resmsg, err = HedgedCall(rootCtx, time.Second, clients, jsoff.NewRequestMessage(2, "whoami", nil))
+	assert.Nil(err)
+	assert.Equal("fast", resmsg.MustResult())
+	assert.True(time.Since(start) < 500*time.Millisecond)
+
+	// balanced client hedges the listed methods
+	bc, err := NewBalancedClient([]string{
+		"http://127.0.0.1:28019",
+		"http://127.0.0.1:28020",
+	}, BalancedOptions{
+		HedgeDelay:   50 * time.Millisecond,
+		HedgeMethods: []string{"whoami"},
+	})
+	assert.Nil(err)
+	defer bc.Close()
+	for i := 0; i < 4; i++ {
+		resmsg, err := bc.Call(rootCtx, jsoff.NewRequestMessage(i, "whoami", nil))
+		assert.Nil(err)
+		assert.Equal("fast", resmsg.MustResult())
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startNamedServer(rootCtx, "a", "127.0.0.1:28022")
+	startNamedServer(rootCtx, "b", "127.0.0.1:28023")
+	time.Sleep(10 * time.Millisecond)
+
+	// the same streaming client twice, the clones have unique ids
+	ws := mustClient(t, "ws://127.0.0.1:28023")
+	clients := []Client{
+		mustClient(t, "http://127.0.0.1:28022"),
+		ws,
+		ws,
+		// nothing listens on the port
+		mustClient(t, "http://127.0.0.1:28024"),
+	}
+	results := FanOut(rootCtx, clients, jsoff.NewRequestMessage(10, "whoami", nil))
+	assert.Equal(4, len(results))
+	assert.Nil(results[0].Err)
+	assert.Equal("a", results[0].Result.MustResult())
+	assert.Equal(10, results[0].Result.MustId())
+	assert.Nil(results[1].Err)
+	assert.Equal("b", results[1].Result.MustResult())
+	assert.Nil(results[2].Err)
+	assert.Equal("b", results[2].Result.MustResult())
+	assert.NotNil(results[3].Err)
+	assert.Equal(clients[3], results[3].Client)
+}
+
+func TestHedgeIds(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewGatewayHandler(rootCtx, nil, true)
+	server.Actor.OnRequest("myid", func(req *RPCRequest, params []any) (any, error) {
+		return req.Msg().MustId(), nil
+	})
+	go ListenAndServe(rootCtx, "127.0.0.1:28031", server)
+	time.Sleep(10 * time.Millisecond)
+
+	// no clients to call
+	_, err := HedgedCall(rootCtx, time.Second, nil, jsoff.NewRequestMessage(1, "myid", nil))
+	assert.NotNil(err)
+	assert.Equal(0, len(FanOut(rootCtx, nil, jsoff.NewRequestMessage(1, "myid", nil))))
+
+	// the clones take the ids of the client's IdGenerator
+	client, err := NewClient("http://127.0.0.1:28031", ClientOptions{
+		IdGenerator: NewSequentialIdGenerator(),
+	})
+	assert.Nil(err)
+	results := FanOut(rootCtx, []Client{client, client}, jsoff.NewRequestMessage("abc", "myid", nil))
+	ids := []string{}
+	for _, res := range results {
+		assert.Nil(res.Err)
+		assert.Equal("abc", res.Result.MustId())
+		ids = append(ids, fmt.Sprint(res.Result.MustResult()))
+	}
+	assert.ElementsMatch([]string{"1", "2"}, ids)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +29,14 @@ type Http1Client struct {
 	clientTLS *tls.Config
 }
 
+func init() {
+	factory := func(serverUrl *url.URL, opts ClientOptions) (Client, error) {
+		return NewHttp1Client(serverUrl, opts), nil
+	}
+	RegisterClientScheme("http", factory)
+	RegisterClientScheme("https", factory)
+}
+
 func NewHttp1Client(serverUrl *url.URL, optlist ...ClientOptions) *Http1Client {
 	if serverUrl.Scheme != "http" && serverUrl.Scheme != "https" {
 		log.Panicf("server url %s is not http", serverUrl)
@@ -84,12 +93,17 @@ func (self *Http1Client) UnwrapCall(rootCtx context.Context, reqmsg *jsoff.Reque
 		}
 		return nil
 	} else {
-		return resmsg.MustError()
+		return messageError(reqmsg, resmsg)
 	}
 }
 
 func (client *Http1Client) Call(rootCtx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
-	resmsg, err := client.request(rootCtx, reqmsg)
+	assignId(client.clientOptions.IdGenerator, reqmsg)
+	return intercept(rootCtx, client.clientOptions.Interceptors, reqmsg, callStep(client.retryCall))
+}
+
+func (client *Http1Client) retryCall(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	resmsg, err := withRetry(ctx, client.clientOptions.Retry, reqmsg, client.request)
 	if err != nil {
 		return resmsg, errors.Wrapf(err, "RPC(%s)", reqmsg.Method)
 	}
@@ -119,16 +133,13 @@ func (client *Http1Client) request(rootCtx context.Context, reqmsg *jsoff.Reques
 	if traceId != "" {
 		req.Header.Add("X-Trace-Id", traceId)
 	}
+	if timeout := contextTimeout(rootCtx); timeout > 0 {
+		req.Header.Set(TimeoutHeader, strconv.FormatInt(timeout, 10))
+	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	if client.extraHeader != nil {
-		for k, vs := range client.extraHeader {
-			for _, v := range vs {
-				req.Header.Add(k, v)
-			}
-		}
-	}
+	client.addHeaders(ctx, req)
 
 	resp, err := client.httpClient.Do(req)
 	if err != nil {
@@ -182,6 +193,13 @@ func (client *Http1Client) request(rootCtx context.Context, reqmsg *jsoff.Reques
 }
 
 func (client *Http1Client) Send(rootCtx context.Context, msg jsoff.Message) error {
+	_, err := intercept(rootCtx, client.clientOptions.Interceptors, msg, sendStep(func(ctx context.Context, msg jsoff.Message) error {
+		return classifyError(client.send(ctx, msg))
+	}))
+	return err
+}
+
+func (client *Http1Client) send(rootCtx context.Context, msg jsoff.Message) error {
 	client.connect()
 
 	traceId := msg.TraceId()
@@ -206,13 +224,7 @@ func (client *Http1Client) Send(rootCtx context.Context, msg jsoff.Message) erro
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	if client.extraHeader != nil {
-		for k, vs := range client.extraHeader {
-			for _, v := range vs {
-				req.Header.Add(k, v)
-			}
-		}
-	}
+	client.addHeaders(ctx, req)
 
 	resp, err := client.httpClient.Do(req)
 	if err != nil {
@@ -229,6 +241,18 @@ func (client *Http1Client) Send(rootCtx context.Context, msg jsoff.Message) erro
 	return nil
 }
 
+// addHeaders adds the extra header of client and the header of ctx
+// to req
+func (client *Http1Client) addHeaders(ctx context.Context, req *http.Request) {
+	for _, h := range []http.Header{client.extraHeader, headerFromContext(ctx)} {
+		for k, vs := range h {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+}
+
 func (client *Http1Client) IsStreaming() bool {
 	return false
 }
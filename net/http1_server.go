@@ -2,6 +2,7 @@ package jsoffnet
 
 import (
 	"bytes"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -63,6 +64,12 @@ func (handler *Http1Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if reqmsg, ok := msg.(*jsoff.RequestMessage); ok && reqmsg.Timeout == 0 {
+		// the caller deadline passed by header
+		if timeout, err := strconv.ParseInt(r.Header.Get(TimeoutHeader), 10, 64); err == nil && timeout > 0 {
+			reqmsg.Timeout = timeout
+		}
+	}
 	req := NewRPCRequest(r.Context(), msg, TransportHTTP).WithHTTPRequest(r)
 	resmsg, err := handler.Actor.Feed(req)
 	if err != nil {
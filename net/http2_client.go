@@ -29,14 +29,24 @@ type Http2Client struct {
 }
 
 type h2Transport struct {
-	client  *Http2Client
+	client *Http2Client
+	// protects the fields below, which are replaced when connecting
+	lock    sync.Mutex
 	resp    *http.Response
 	decoder *json.Decoder
 	writer  io.Writer
 	flusher http.Flusher
 }
 
-func NewHttp2Client(serverUrl *url.URL) *Http2Client {
+func init() {
+	factory := func(serverUrl *url.URL, opts ClientOptions) (Client, error) {
+		return NewHttp2Client(serverUrl, opts), nil
+	}
+	RegisterClientScheme("h2", factory)
+	RegisterClientScheme("h2c", factory)
+}
+
+func NewHttp2Client(serverUrl *url.URL, optlist ...ClientOptions) *Http2Client {
 	newUrl, err := url.Parse(serverUrl.String())
 	useh2c := false
 	if err != nil {
@@ -53,7 +63,7 @@ func NewHttp2Client(serverUrl *url.URL) *Http2Client {
 	}
 	c := &Http2Client{UseHttp2C: useh2c}
 	transport := &h2Transport{client: c}
-	c.InitStreaming(newUrl, transport)
+	c.InitStreaming(newUrl, transport, optlist...)
 	return c
 }
 
@@ -93,6 +103,8 @@ func (client *Http2Client) String() string {
 
 // http2 transport methods
 func (t *h2Transport) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	if t.resp != nil {
 		t.resp.Body.Close()
 		t.resp = nil
@@ -102,10 +114,22 @@ func (t *h2Transport) Close() {
 	}
 }
 
-func (t h2Transport) Connected() bool {
+func (t *h2Transport) Type() string {
+	return TransportHTTP2
+}
+
+func (t *h2Transport) Connected() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	return t.resp != nil
 }
 
+func (t *h2Transport) current() (io.Writer, *json.Decoder) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.writer, t.decoder
+}
+
 func (t *h2Transport) Connect(rootCtx context.Context, serverUrl *url.URL, header http.Header) error {
 	pipeReader, pipeWriter := io.Pipe()
 
@@ -120,6 +144,14 @@ func (t *h2Transport) Connect(rootCtx context.Context, serverUrl *url.URL, heade
 	if err != nil {
 		return t.handleHttp2Error(err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		// the session is refused by server
+		resp.Body.Close()
+		pipeWriter.Close()
+		return errors.Wrapf(TransportConnectFailed, "h2 connect status %d", resp.StatusCode)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	t.writer = pipeWriter
 	t.resp = resp
 	t.decoder = json.NewDecoder(resp.Body)
@@ -148,14 +180,22 @@ func (t *h2Transport) WriteMessage(msg jsoff.Message) error {
 	}
 
 	marshaled = append(marshaled, []byte("\n")...)
-	if _, err := t.writer.Write(marshaled); err != nil {
+	writer, _ := t.current()
+	if writer == nil {
+		return TransportClosed
+	}
+	if _, err := writer.Write(marshaled); err != nil {
 		return t.handleHttp2Error(err)
 	}
 	return nil
 }
 
 func (t *h2Transport) ReadMessage() (jsoff.Message, bool, error) {
-	msg, err := jsoff.DecodeMessage(t.decoder)
+	writer, decoder := t.current()
+	if writer == nil {
+		return nil, false, TransportClosed
+	}
+	msg, err := jsoff.DecodeMessage(decoder)
 	if err != nil {
 		if errors.Is(err, io.EOF) {
 			return nil, false, TransportClosed
@@ -164,7 +204,7 @@ func (t *h2Transport) ReadMessage() (jsoff.Message, bool, error) {
 		}
 		t.client.Log().Warnf(
 			"bad jsonrpc message %s %s, at pos %d",
-			reflect.TypeOf(err), err, t.decoder.InputOffset())
+			reflect.TypeOf(err), err, decoder.InputOffset())
 		return nil, false, err
 	}
 	return msg, true, nil
@@ -4,23 +4,28 @@ import (
 	"context"
 	"encoding/json"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/superisaac/jsoff"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 )
 
 type Http2Handler struct {
-	Actor     *Actor
-	serverCtx context.Context
+	Actor *Actor
+	// the live sessions
+	Sessions *SessionRegistry
 	// options
 	SpawnGoroutine bool
 	UseHttp2C      bool
 
 	fallbackHandler *Http1Handler
 	fallbackOnce    sync.Once
+
+	shuttingDown atomic.Bool
 }
 
 type Http2Session struct {
@@ -32,16 +37,23 @@ type Http2Session struct {
 	rootCtx     context.Context
 	done        chan error
 	sendChannel chan jsoff.Message
+	queue       chan func()
 	sessionId   string
+	caller      *sessionCaller
+	inflight    *inflightRequests
+	sessionMeta
 }
 
+// NewHttp2Handler creates a http2 handler, the sessions are not
+// ended when serverCtx is done but by Shutdown, which ListenAndServe
+// calls on its ctx done.
 func NewHttp2Handler(serverCtx context.Context, actor *Actor) *Http2Handler {
 	if actor == nil {
 		actor = NewActor()
 	}
 	return &Http2Handler{
-		serverCtx:      serverCtx,
 		Actor:          actor,
+		Sessions:       NewSessionRegistry(),
 		SpawnGoroutine: true,
 	}
 }
@@ -59,7 +71,19 @@ func (h *Http2Handler) FallbackHandler() *Http1Handler {
 	return h.fallbackHandler
 }
 
+// Shutdown stops accepting new sessions and gracefully shuts down the
+// live sessions, which are closed forcibly when ctx is done.
+func (h *Http2Handler) Shutdown(ctx context.Context) error {
+	h.shuttingDown.Store(true)
+	return h.Sessions.shutdown(ctx, h)
+}
+
 func (h *Http2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("server is shutting down"))
+		return
+	}
 	if !h.UseHttp2C && !r.ProtoAtLeast(2, 0) {
 		//return fmt.Errorf("HTTP2 not supported")
 		//w.WriteHeader(400)
@@ -75,10 +99,6 @@ func (h *Http2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	//w.Write([]byte("{\"method\":\"hello\",\"params\":[]}\n"))
-	flusher.Flush()
-
 	decoder := json.NewDecoder(r.Body)
 	session := &Http2Session{
 		server:      h,
@@ -89,11 +109,30 @@ func (h *Http2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		decoder:     decoder,
 		done:        make(chan error, 10),
 		sendChannel: make(chan jsoff.Message, 100),
+		queue:       make(chan func(), 100),
 		sessionId:   jsoff.NewUuid(),
+		caller:      newSessionCaller(),
+		inflight:    &inflightRequests{},
+		sessionMeta: newHTTPSessionMeta(r),
 	}
 	defer func() {
+		h.Sessions.remove(session)
 		r.Body.Close()
+		session.caller.close()
+		session.inflight.cancelAll()
 		h.Actor.HandleClose(session)
 	}()
+	if err := h.Actor.HandleConnect(session, r); err != nil {
+		log.Infof("http2 session refused, %s", err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	//w.Write([]byte("{\"method\":\"hello\",\"params\":[]}\n"))
+	flusher.Flush()
+
+	h.Sessions.add(session)
 	session.wait()
 }
@@ -14,18 +14,26 @@ func (session *Http2Session) wait() {
 	connCtx, cancel := context.WithCancel(session.rootCtx)
 	defer cancel()
 
-	serverCtx, cancelServer := context.WithCancel(session.server.serverCtx)
-	defer cancelServer()
-
-	go session.sendLoop()
+	// the response writer must not be used after the handler
+	// returns, so wait for sendLoop to stop
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		session.sendLoop(connCtx)
+	}()
+	defer func() {
+		cancel()
+		<-sendDone
+	}()
 	go session.recvLoop()
+	if !session.server.SpawnGoroutine {
+		go runQueue(connCtx, session.queue)
+	}
 
 	for {
 		select {
 		case <-connCtx.Done():
 			return
-		case <-serverCtx.Done():
-			return
 		case err, ok := <-session.done:
 			if ok && err != nil {
 				log.Warnf("websocket error %s", err)
@@ -46,19 +54,34 @@ func (session *Http2Session) recvLoop() {
 				return
 			}
 		}
+		if msg.IsResultOrError() && session.caller.handleResult(msg) {
+			// the result of a request sent to the client
+			continue
+		}
+		if session.inflight.handleCancel(msg) {
+			continue
+		}
+
+		ctx, done := session.inflight.begin(session.rootCtx, msg)
 		if session.server.SpawnGoroutine {
-			go session.msgReceived(msg)
+			go session.msgReceived(ctx, msg, done)
 		} else {
-			session.msgReceived(msg)
+			select {
+			case session.queue <- func() { session.msgReceived(ctx, msg, done) }:
+			case <-session.rootCtx.Done():
+				done()
+				return
+			}
 		}
 	}
 	// end of scanning
 	session.done <- nil
 }
 
-func (session *Http2Session) msgReceived(msg jsoff.Message) {
+func (session *Http2Session) msgReceived(ctx context.Context, msg jsoff.Message, done func()) {
+	defer done()
 	req := NewRPCRequest(
-		session.rootCtx,
+		ctx,
 		msg,
 		TransportHTTP2).WithHTTPRequest(session.httpRequest).WithSession(session)
 
@@ -80,6 +103,26 @@ func (session *Http2Session) Send(msg jsoff.Message) {
 	session.sendChannel <- msg
 }
 
+func (session *Http2Session) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	return session.caller.call(ctx, session.Send, reqmsg)
+}
+
+// close is called by the session registry
+func (session *Http2Session) close(reason string) {
+	select {
+	case session.done <- errors.New("session closed, " + reason):
+	default:
+	}
+}
+
+func (session *Http2Session) shutdown(ctx context.Context) error {
+	return shutdownSession(ctx, session, session.sendChannel, session.inflight, session.caller)
+}
+
+func (session *Http2Session) owner() any {
+	return session.server
+}
+
 func (session Http2Session) SessionID() string {
 	return session.sessionId
 }
@@ -88,10 +131,7 @@ func (session Http2Session) Context() context.Context {
 	return session.rootCtx
 }
 
-func (session *Http2Session) sendLoop() {
-	ctx, cancel := context.WithCancel(session.rootCtx)
-	defer cancel()
-
+func (session *Http2Session) sendLoop(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -100,6 +140,12 @@ func (session *Http2Session) sendLoop() {
 			if !ok {
 				return
 			}
+			if msg == nil {
+				// the session is shutting down and the pending
+				// messages are written
+				session.done <- nil
+				return
+			}
 			if session.decoder == nil {
 				return
 			}
@@ -3,8 +3,11 @@ package jsoffnet
 import (
 	"context"
 	"encoding/json"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/superisaac/jsoff"
+	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -131,7 +134,7 @@ func TestTypedHttp2HandlerClient(t *testing.T) {
 	assert.True(resmsg2.IsError())
 	errbody2 := resmsg2.MustError()
 	assert.Equal(-32602, errbody2.Code)
-	assert.Equal("no enough params size", errbody2.Message)
+	assert.Equal("params[0] is missing, expect string", errbody2.Message)
 
 	// test add 2 numbers
 	params3 := []any{6, 3}
@@ -174,7 +177,7 @@ func TestHttp2Close(t *testing.T) {
 	go ListenAndServe(serverCtx, "127.0.0.1:28723", server, serverTLS())
 	time.Sleep(100 * time.Millisecond)
 
-	closeCalled := make(map[int]bool)
+	var closeCalled atomic.Bool
 	client := NewHttp2Client(urlParse("h2://127.0.0.1:28723"))
 	client.SetClientTLSConfig(clientTLS())
 
@@ -183,7 +186,7 @@ func TestHttp2Close(t *testing.T) {
 		connectedCalled[0] = true
 	})
 	client.OnClose(func() {
-		closeCalled[0] = true
+		closeCalled.Store(true)
 	})
 	// right request
 	params := []any{"hello1002"}
@@ -200,5 +203,60 @@ func TestHttp2Close(t *testing.T) {
 	// cancel root
 	cancelServer()
 	time.Sleep(100 * time.Millisecond)
-	assert.True(closeCalled[0])
+	assert.True(closeCalled.Load())
+}
+
+func TestHttp2Progress(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewHttp2Handler(rootCtx, nil)
+	server.Actor.OnRequest("count", func(req *RPCRequest, params []any) (any, error) {
+		for i := 1; i <= 3; i++ {
+			req.Progress(i)
+		}
+		return "done", nil
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28801", server.Http2CHandler(), nil)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewHttp2Client(urlParse("h2c://127.0.0.1:28801"))
+
+	values := []any{}
+	ctx := ContextWithProgress(rootCtx, func(value any) {
+		values = append(values, value)
+	})
+	reqmsg := jsoff.NewRequestMessage(1, "count", nil)
+	resmsg, err := client.Call(ctx, reqmsg)
+	assert.Nil(err)
+	assert.Equal("done", resmsg.MustResult())
+	assert.Equal([]any{json.Number("1"), json.Number("2"), json.Number("3")}, values)
+
+	// calls without a progress handler ignore the progress
+	reqmsg1 := jsoff.NewRequestMessage("abc", "count", nil)
+	resmsg1, err := client.Call(rootCtx, reqmsg1)
+	assert.Nil(err)
+	assert.Equal("done", resmsg1.MustResult())
+}
+
+func TestHttp2OnConnectRefused(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewHttp2Handler(rootCtx, nil)
+	server.Actor.OnConnect(func(session RPCSession, r *http.Request) error {
+		return errors.New("go away")
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28802", server.Http2CHandler(), nil)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewHttp2Client(urlParse("h2c://127.0.0.1:28802"))
+	_, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "echo", nil))
+	assert.ErrorIs(err, TransportConnectFailed)
 }
@@ -16,6 +16,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -204,7 +205,7 @@ func TestTypedServerClient(t *testing.T) {
 	assert.True(resmsg2.IsError())
 	errbody2 := resmsg2.MustError()
 	assert.Equal(-32602, errbody2.Code)
-	assert.Equal("no enough params size", errbody2.Message)
+	assert.Equal("params[0] is missing, expect string", errbody2.Message)
 
 	// test add 2 numbers
 	params3 := []any{6, 3}
@@ -245,6 +246,80 @@ func TestTypedServerClient(t *testing.T) {
 	assert.Equal(107, res6)
 }
 
+func TestTypedOptionalParams(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewHttp1Handler(nil)
+	server.Actor.OnTyped("greet", func(name string, title *string) (string, error) {
+		if title == nil {
+			return "hello " + name, nil
+		}
+		return fmt.Sprintf("hello %s %s", *title, name), nil
+	})
+
+	server.Actor.OnTyped("sum", func(base int, nums ...int) (int, error) {
+		for _, n := range nums {
+			base += n
+		}
+		return base, nil
+	})
+
+	server.Actor.OnTyped("scale", func(a int, factor int) (int, error) {
+		return a * factor, nil
+	}, WithSchemaJson(`{"type": "method", "params": ["integer", {"type": "integer", "default": 10}]}`))
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28002", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewHttp1Client(urlParse("http://127.0.0.1:28002"))
+
+	// optional pointer param absent
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "greet", []any{"jake"}))
+	assert.Nil(err)
+	assert.Equal("hello jake", resmsg.MustResult())
+
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(2, "greet", []any{"jake", "Mr."}))
+	assert.Nil(err)
+	assert.Equal("hello Mr. jake", resmsg.MustResult())
+
+	// too many params
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(3, "greet", []any{"jake", "Mr.", 5}))
+	assert.Nil(err)
+	assert.True(resmsg.IsError())
+	assert.Equal(-32602, resmsg.MustError().Code)
+	assert.Equal("params[2] is unexpected, expect at most 2 params", resmsg.MustError().Message)
+
+	// variadic params
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(4, "sum", []any{1}))
+	assert.Nil(err)
+	assert.Equal(json.Number("1"), resmsg.MustResult())
+
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(5, "sum", []any{1, 2, 3, 4}))
+	assert.Nil(err)
+	assert.Equal(json.Number("10"), resmsg.MustResult())
+
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(6, "sum", []any{1, 2, "x"}))
+	assert.Nil(err)
+	assert.True(resmsg.IsError())
+	assert.Contains(resmsg.MustError().Message, "params[2] expect int")
+
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(7, "sum", []any{}))
+	assert.Nil(err)
+	assert.Equal("params[0] is missing, expect int", resmsg.MustError().Message)
+
+	// default values from schema
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(8, "scale", []any{3}))
+	assert.Nil(err)
+	assert.Equal(json.Number("30"), resmsg.MustResult())
+
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(9, "scale", []any{3, 2}))
+	assert.Nil(err)
+	assert.Equal(json.Number("6"), resmsg.MustResult())
+}
+
 func TestHandlerSchema(t *testing.T) {
 	assert := assert.New(t)
 
@@ -622,3 +697,137 @@ func TestActors(t *testing.T) {
 	actor1.Off("add2num")
 	assert.False(main_actor.Has("add2num"))
 }
+
+func TestHandlerTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	actor := NewActor()
+	actor.Timeout = 50 * time.Millisecond
+
+	deadlined := make(chan bool, 1)
+	actor.OnContext("ignoreCtx", func(ctx context.Context, params []any) (any, error) {
+		_, ok := ctx.Deadline()
+		deadlined <- ok
+		time.Sleep(time.Second)
+		return "late", nil
+	})
+	actor.On("quick", func(params []any) (any, error) {
+		return "ok", nil
+	})
+	actor.On("slowButAllowed", func(params []any) (any, error) {
+		time.Sleep(100 * time.Millisecond)
+		return "ok", nil
+	}, WithTimeout(time.Second))
+
+	feed := func(method string) jsoff.Message {
+		reqmsg := jsoff.NewRequestMessage(1, method, nil)
+		resmsg, err := actor.Feed(NewRPCRequest(context.Background(), reqmsg, TransportHTTP))
+		assert.Nil(err)
+		return resmsg
+	}
+
+	start := time.Now()
+	resmsg := feed("ignoreCtx")
+	assert.True(time.Since(start) < 500*time.Millisecond)
+	assert.True(<-deadlined)
+	assert.True(resmsg.IsError())
+	assert.Equal(jsoff.ErrTimeout.Code, resmsg.MustError().Code)
+
+	assert.Equal("ok", feed("quick").MustResult())
+	assert.Equal("ok", feed("slowButAllowed").MustResult())
+}
+
+func TestHttp1DeadlinePropagation(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	called := make(chan bool, 1)
+	server := NewHttp1Handler(nil)
+	server.Actor.OnContext("deadline", func(ctx context.Context, params []any) (any, error) {
+		called <- true
+		_, ok := ctx.Deadline()
+		return ok, nil
+	})
+	go ListenAndServe(rootCtx, "127.0.0.1:28004", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewHttp1Client(urlParse("http://127.0.0.1:28004"))
+	callCtx, cancelCall := context.WithTimeout(rootCtx, time.Second)
+	defer cancelCall()
+	resmsg, err := client.Call(callCtx, jsoff.NewRequestMessage(1, "deadline", nil))
+	assert.Nil(err)
+	assert.Equal(true, resmsg.MustResult())
+	<-called
+
+	// the work already past its deadline is skipped
+	actor := NewActor()
+	actor.On("skipped", func(params []any) (any, error) {
+		called <- true
+		return nil, nil
+	})
+	reqmsg := jsoff.NewRequestMessage(2, "skipped", nil)
+	reqmsg.Timeout = 1
+	req := NewRPCRequest(rootCtx, reqmsg, TransportHTTP)
+	time.Sleep(5 * time.Millisecond)
+	resmsg, err = actor.Feed(req)
+	assert.Nil(err)
+	assert.Equal(jsoff.ErrTimeout.Code, resmsg.MustError().Code)
+	assert.Equal(0, len(called))
+}
+
+func TestHttp1Retry(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewHttp1Handler(nil)
+	server.Actor.On("echo", func(params []any) (any, error) {
+		return params[0], nil
+	})
+
+	// the first two requests are rejected
+	var ids []string
+	var lock sync.Mutex
+	flaky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		msg, err := jsoff.ParseBytes(body)
+		assert.Nil(err)
+		lock.Lock()
+		ids = append(ids, fmt.Sprintf("%v", msg.MustId()))
+		attempts := len(ids)
+		lock.Unlock()
+		if attempts <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		server.ServeHTTP(w, r)
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28005", flaky)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewHttp1Client(urlParse("http://127.0.0.1:28005"), ClientOptions{
+		Retry: RetryPolicy{
+			MaxAttempts:       3,
+			InitialDelay:      10 * time.Millisecond,
+			IdempotentMethods: []string{"echo"},
+		},
+	})
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "echo", []any{"hi"}))
+	assert.Nil(err)
+	assert.Equal("hi", resmsg.MustResult())
+	// the retries reuse the request id
+	assert.Equal([]string{"1", "1", "1"}, ids)
+
+	// methods not in the allowlist are not retried
+	ids = nil
+	_, err = client.Call(rootCtx, jsoff.NewRequestMessage(2, "write", []any{"hi"}))
+	var wrappedResp *WrappedResponse
+	assert.True(errors.As(err, &wrappedResp))
+	assert.Equal(http.StatusServiceUnavailable, wrappedResp.Response.StatusCode)
+	assert.Equal(1, len(ids))
+}
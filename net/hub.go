@@ -0,0 +1,282 @@
+package jsoffnet
+
+import (
+	"sync"
+
+	"github.com/superisaac/jsoff"
+)
+
+// the notify method of messages published by hub.publish
+const HubMessageMethod = "hub.message"
+
+// SlowConsumerPolicy decides what to do when the buffer of a session
+// is full
+type SlowConsumerPolicy int
+
+const (
+	// drop the message being published
+	HubDropNewest SlowConsumerPolicy = iota
+	// drop the oldest message in buffer to make room
+	HubDropOldest
+	// remove the session from all topics
+	HubEvict
+)
+
+// HubFilter returns whether msg should be delivered to session
+type HubFilter func(session RPCSession, msg jsoff.Message) bool
+
+type hubMember struct {
+	session RPCSession
+	topics  map[string]bool
+	buffer  chan jsoff.Message
+	done    chan struct{}
+}
+
+func (m *hubMember) sendLoop(hub *Hub) {
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.session.Context().Done():
+			// the session may be closed without the close hook of
+			// a mounting actor, remove it from hub here
+			hub.lock.Lock()
+			if hub.members[m.session.SessionID()] == m {
+				hub.evict(m)
+			}
+			hub.lock.Unlock()
+			return
+		case msg := <-m.buffer:
+			m.session.Send(msg)
+		}
+	}
+}
+
+// push msg to member buffer, returns false if the member should be
+// evicted
+func (m *hubMember) push(msg jsoff.Message, policy SlowConsumerPolicy) bool {
+	select {
+	case m.buffer <- msg:
+		return true
+	default:
+	}
+	switch policy {
+	case HubDropOldest:
+		select {
+		case <-m.buffer:
+		default:
+		}
+		select {
+		case m.buffer <- msg:
+		default:
+			msg.Log().Warnf("hub buffer of session %s is full, message dropped", m.session.SessionID())
+		}
+		return true
+	case HubEvict:
+		msg.Log().Warnf("hub session %s is too slow, evicted", m.session.SessionID())
+		return false
+	default:
+		msg.Log().Warnf("hub buffer of session %s is full, message dropped", m.session.SessionID())
+		return true
+	}
+}
+
+// Hub broadcasts messages to the sessions joined a topic
+type Hub struct {
+	// the buffer size of each session
+	BufferSize int
+	// policy applied when the buffer of a session is full
+	SlowConsumerPolicy SlowConsumerPolicy
+
+	lock    sync.RWMutex
+	members map[string]*hubMember
+	topics  map[string]map[string]*hubMember
+	filters map[string]HubFilter
+	actor   *Actor
+}
+
+func NewHub() *Hub {
+	hub := &Hub{
+		BufferSize:         100,
+		SlowConsumerPolicy: HubDropNewest,
+		members:            make(map[string]*hubMember),
+		topics:             make(map[string]map[string]*hubMember),
+		filters:            make(map[string]HubFilter),
+	}
+	hub.actor = hub.newActor()
+	return hub
+}
+
+// Join adds session to the topic, the session is removed from all
+// topics when its context is done
+func (hub *Hub) Join(topic string, session RPCSession) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	sessionId := session.SessionID()
+	m, ok := hub.members[sessionId]
+	if !ok {
+		bufferSize := hub.BufferSize
+		if bufferSize <= 0 {
+			bufferSize = 1
+		}
+		m = &hubMember{
+			session: session,
+			topics:  make(map[string]bool),
+			buffer:  make(chan jsoff.Message, bufferSize),
+			done:    make(chan struct{}),
+		}
+		hub.members[sessionId] = m
+		go m.sendLoop(hub)
+	}
+	m.topics[topic] = true
+	tmembers, ok := hub.topics[topic]
+	if !ok {
+		tmembers = make(map[string]*hubMember)
+		hub.topics[topic] = tmembers
+	}
+	tmembers[sessionId] = m
+}
+
+// Leave removes session from the topic, returns false if session is
+// not in the topic
+func (hub *Hub) Leave(topic string, session RPCSession) bool {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	m, ok := hub.members[session.SessionID()]
+	if !ok || !m.topics[topic] {
+		return false
+	}
+	hub.leave(topic, m)
+	return true
+}
+
+// LeaveAll removes session from all topics
+func (hub *Hub) LeaveAll(session RPCSession) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	if m, ok := hub.members[session.SessionID()]; ok {
+		hub.evict(m)
+	}
+}
+
+func (hub *Hub) leave(topic string, m *hubMember) {
+	sessionId := m.session.SessionID()
+	delete(m.topics, topic)
+	if tmembers, ok := hub.topics[topic]; ok {
+		delete(tmembers, sessionId)
+		if len(tmembers) == 0 {
+			delete(hub.topics, topic)
+		}
+	}
+	if len(m.topics) == 0 {
+		delete(hub.members, sessionId)
+		close(m.done)
+	}
+}
+
+func (hub *Hub) evict(m *hubMember) {
+	for topic := range m.topics {
+		hub.leave(topic, m)
+	}
+}
+
+// SetFilter sets the filter of a topic, nil filter removes it
+func (hub *Hub) SetFilter(topic string, filter HubFilter) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	if filter == nil {
+		delete(hub.filters, topic)
+	} else {
+		hub.filters[topic] = filter
+	}
+}
+
+// Topics returns the topics the session joined
+func (hub *Hub) Topics(session RPCSession) []string {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+	topics := []string{}
+	if m, ok := hub.members[session.SessionID()]; ok {
+		for topic := range m.topics {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// Publish delivers msg to the sessions of topic, returns the number
+// of sessions msg is delivered to
+func (hub *Hub) Publish(topic string, msg jsoff.Message) int {
+	hub.lock.RLock()
+	filter := hub.filters[topic]
+	cnt := 0
+	var evicted []*hubMember
+	for _, m := range hub.topics[topic] {
+		if filter != nil && !filter(m.session, msg) {
+			continue
+		}
+		if m.push(msg, hub.SlowConsumerPolicy) {
+			cnt++
+		} else {
+			evicted = append(evicted, m)
+		}
+	}
+	hub.lock.RUnlock()
+
+	if len(evicted) > 0 {
+		hub.lock.Lock()
+		defer hub.lock.Unlock()
+		for _, m := range evicted {
+			// the member may have left meanwhile
+			if hub.members[m.session.SessionID()] == m {
+				hub.evict(m)
+			}
+		}
+	}
+	return cnt
+}
+
+// Actor returns the actor serving hub.subscribe, hub.unsubscribe and
+// hub.publish
+func (hub *Hub) Actor() *Actor {
+	return hub.actor
+}
+
+// Mount adds the hub methods to actor, the sessions are removed from
+// hub when closed.
+func (hub *Hub) Mount(actor *Actor) {
+	actor.AddChild(hub.actor)
+}
+
+func (hub *Hub) newActor() *Actor {
+	actor := NewActor()
+	actor.OnTypedRequest("hub.subscribe", func(req *RPCRequest, topic string) (bool, error) {
+		session := req.Session()
+		if session == nil {
+			return false, jsoff.ErrNotAllowed.WithData("hub requires a streaming session")
+		}
+		hub.Join(topic, session)
+		return true, nil
+	})
+
+	actor.OnTypedRequest("hub.unsubscribe", func(req *RPCRequest, topic string) (bool, error) {
+		session := req.Session()
+		if session == nil {
+			return false, jsoff.ErrNotAllowed.WithData("hub requires a streaming session")
+		}
+		return hub.Leave(topic, session), nil
+	})
+
+	actor.OnTyped("hub.publish", func(topic string, data any) (int, error) {
+		ntfmsg := jsoff.NewNotifyMessage(HubMessageMethod, map[string]any{
+			"topic": topic,
+			"data":  data,
+		})
+		return hub.Publish(topic, ntfmsg), nil
+	})
+
+	actor.OnClose(func(session RPCSession) {
+		hub.LeaveAll(session)
+	})
+	return actor
+}
@@ -0,0 +1,175 @@
+package jsoffnet
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+// an in memory session collecting the messages sent
+type memSession struct {
+	sessionMeta
+	ctx      context.Context
+	id       string
+	messages chan jsoff.Message
+}
+
+func newMemSession(ctx context.Context, size int) *memSession {
+	return &memSession{
+		sessionMeta: newSessionMeta("mem", nil),
+		ctx:         ctx,
+		id:          jsoff.NewUuid(),
+		messages:    make(chan jsoff.Message, size),
+	}
+}
+
+func (session memSession) Context() context.Context {
+	return session.ctx
+}
+
+func (session *memSession) Send(msg jsoff.Message) {
+	session.messages <- msg
+}
+
+func (session memSession) SessionID() string {
+	return session.id
+}
+
+func (session memSession) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	return nil, TransportClosed
+}
+
+func TestHubPublish(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	s1 := newMemSession(ctx, 10)
+	s2 := newMemSession(ctx, 10)
+	hub.Join("news", s1)
+	hub.Join("news", s2)
+	hub.Join("sports", s2)
+
+	assert.Equal(2, hub.Publish("news", jsoff.NewNotifyMessage("hello", nil)))
+	assert.Equal("hello", (<-s1.messages).MustMethod())
+	assert.Equal("hello", (<-s2.messages).MustMethod())
+
+	// topic filter
+	hub.SetFilter("news", func(session RPCSession, msg jsoff.Message) bool {
+		return session.SessionID() == s2.SessionID()
+	})
+	assert.Equal(1, hub.Publish("news", jsoff.NewNotifyMessage("filtered", nil)))
+	assert.Equal("filtered", (<-s2.messages).MustMethod())
+	hub.SetFilter("news", nil)
+
+	assert.True(hub.Leave("news", s1))
+	assert.False(hub.Leave("news", s1))
+	assert.Equal(1, hub.Publish("news", jsoff.NewNotifyMessage("hello", nil)))
+	assert.Equal(0, hub.Publish("nothing", jsoff.NewNotifyMessage("hello", nil)))
+
+	// HandleClose of the mounting actor removes the session
+	actor := NewActor()
+	hub.Mount(actor)
+	actor.HandleClose(s2)
+	assert.Equal(0, len(hub.Topics(s2)))
+	assert.Equal(0, hub.Publish("sports", jsoff.NewNotifyMessage("hello", nil)))
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	hub.BufferSize = 2
+	hub.SlowConsumerPolicy = HubEvict
+
+	// a session never consumes
+	blocked := newMemSession(ctx, 0)
+	hub.Join("news", blocked)
+	cnt := 0
+	for i := 0; i < 5; i++ {
+		cnt += hub.Publish("news", jsoff.NewNotifyMessage("hello", nil))
+	}
+	// the send loop holds one message, the buffer holds two
+	assert.True(cnt >= 2 && cnt <= 3)
+	assert.Equal(0, len(hub.Topics(blocked)))
+}
+
+func TestHubMethods(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	actor := NewActor()
+	hub.Mount(actor)
+
+	session := newMemSession(ctx, 10)
+	feed := func(reqmsg *jsoff.RequestMessage) jsoff.Message {
+		req := NewRPCRequest(ctx, reqmsg, TransportWebsocket).WithSession(session)
+		resmsg, err := actor.Feed(req)
+		assert.Nil(err)
+		return resmsg
+	}
+
+	resmsg := feed(jsoff.NewRequestMessage(1, "hub.subscribe", []any{"news"}))
+	assert.Equal(true, resmsg.MustResult())
+
+	resmsg = feed(jsoff.NewRequestMessage(2, "hub.publish", []any{"news", "hi"}))
+	assert.Equal(1, resmsg.MustResult())
+
+	select {
+	case ntfmsg := <-session.messages:
+		assert.Equal(HubMessageMethod, ntfmsg.MustMethod())
+		bytes, err := json.Marshal(ntfmsg.MustParams()[0])
+		assert.Nil(err)
+		assert.Equal(`{"data":"hi","topic":"news"}`, string(bytes))
+	case <-time.After(time.Second):
+		assert.Fail("hub message not received")
+	}
+
+	resmsg = feed(jsoff.NewRequestMessage(3, "hub.unsubscribe", []any{"news"}))
+	assert.Equal(true, resmsg.MustResult())
+
+	// hub methods require a session
+	req := NewRPCRequest(ctx, jsoff.NewRequestMessage(4, "hub.subscribe", []any{"news"}), TransportHTTP)
+	resmsg, err := actor.Feed(req)
+	assert.Nil(err)
+	assert.True(resmsg.IsError())
+}
+
+func TestHubSessionDone(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the hub is not mounted, the session leaves on its context done
+	hub := NewHub()
+	sessionCtx, sessionCancel := context.WithCancel(ctx)
+	session := newMemSession(sessionCtx, 10)
+	hub.Join("news", session)
+	hub.Join("sports", session)
+	assert.Equal(2, len(hub.Topics(session)))
+
+	sessionCancel()
+	for i := 0; i < 100 && len(hub.Topics(session)) > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(0, len(hub.Topics(session)))
+	assert.Equal(0, hub.Publish("news", jsoff.NewNotifyMessage("hello", nil)))
+
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+	assert.Equal(0, len(hub.members))
+	assert.Equal(0, len(hub.topics))
+}
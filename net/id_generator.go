@@ -0,0 +1,79 @@
+package jsoffnet
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/superisaac/jsoff"
+)
+
+// IdGenerator generates the ids of request messages, the clients
+// assign ids to the requests whose id is nil
+type IdGenerator interface {
+	NextId() any
+}
+
+// SequentialIdGenerator generates the integer ids 1, 2, 3 ...
+type SequentialIdGenerator struct {
+	last atomic.Int64
+}
+
+func NewSequentialIdGenerator() *SequentialIdGenerator {
+	return &SequentialIdGenerator{}
+}
+
+func (g *SequentialIdGenerator) NextId() any {
+	return int(g.last.Add(1))
+}
+
+// UuidGenerator generates uuid strings without dashes, see
+// jsoff.NewUuid
+type UuidGenerator struct{}
+
+func (g UuidGenerator) NextId() any {
+	return jsoff.NewUuid()
+}
+
+// UlidGenerator generates ULIDs, 26 chars strings lexically sorted by
+// the time they are generated
+type UlidGenerator struct{}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (g UlidGenerator) NextId() any {
+	// 48 bits of milliseconds followed by 80 random bits
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(raw[6:]); err != nil {
+		panic(err)
+	}
+	// 128 bits are encoded in 26 chars of 5 bits, with 2 leading
+	// zero bits
+	hi := binary.BigEndian.Uint64(raw[:8])
+	lo := binary.BigEndian.Uint64(raw[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// assignId sets the id of reqmsg by gen if the id is nil, uuids are
+// used if gen is nil
+func assignId(gen IdGenerator, reqmsg *jsoff.RequestMessage) {
+	if reqmsg.Id != nil {
+		return
+	}
+	reqmsg.Id = nextId(gen)
+}
+
+func nextId(gen IdGenerator) any {
+	if gen == nil {
+		return jsoff.NewUuid()
+	}
+	return gen.NextId()
+}
@@ -0,0 +1,70 @@
+package jsoffnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+func TestIdGenerators(t *testing.T) {
+	assert := assert.New(t)
+
+	seq := NewSequentialIdGenerator()
+	assert.Equal(1, seq.NextId())
+	assert.Equal(2, seq.NextId())
+
+	uuid := UuidGenerator{}.NextId().(string)
+	assert.Equal(32, len(uuid))
+
+	gen := UlidGenerator{}
+	prev := gen.NextId().(string)
+	assert.Equal(26, len(prev))
+	assert.Regexp("^[0-7][0-9A-HJKMNP-TV-Z]{25}$", prev)
+	time.Sleep(2 * time.Millisecond)
+	next := gen.NextId().(string)
+	assert.NotEqual(prev, next)
+	// ULIDs sort by time
+	assert.True(prev[:10] < next[:10])
+}
+
+func TestClientAssignIds(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewGatewayHandler(rootCtx, nil, true)
+	server.Actor.OnRequest("myid", func(req *RPCRequest, params []any) (any, error) {
+		return req.Msg().MustId(), nil
+	})
+	go ListenAndServe(rootCtx, "127.0.0.1:28026", server)
+	time.Sleep(10 * time.Millisecond)
+
+	for _, serverUrl := range []string{"http://127.0.0.1:28026", "ws://127.0.0.1:28026"} {
+		client, err := NewClient(serverUrl, ClientOptions{
+			IdGenerator: NewSequentialIdGenerator(),
+		})
+		assert.Nil(err)
+		for i := 1; i <= 3; i++ {
+			resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(nil, "myid", nil))
+			assert.Nil(err)
+			assert.Equal(i, resmsg.MustId())
+			assert.Equal(fmt.Sprint(i), fmt.Sprint(resmsg.MustResult()))
+		}
+		// the given ids are kept
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage("abc", "myid", nil))
+		assert.Nil(err)
+		assert.Equal("abc", resmsg.MustResult())
+	}
+
+	// uuids by default
+	client, err := NewClient("http://127.0.0.1:28026")
+	assert.Nil(err)
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(nil, "myid", nil))
+	assert.Nil(err)
+	assert.Equal(32, len(resmsg.MustResult().(string)))
+}
@@ -0,0 +1,82 @@
+package jsoffnet
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/superisaac/jsoff"
+)
+
+// the streaming clients send their headers once when connecting, a
+// call or send with a ContextWithHeader context fails with it
+var ErrHeaderNotSupported = errors.New("per call headers are not supported by streaming clients")
+
+// ClientCallFunc performs a call or a send, it is the next step of
+// the chain an interceptor forwards to. A send returns no message.
+type ClientCallFunc func(ctx context.Context, msg jsoff.Message) (jsoff.Message, error)
+
+// ClientInterceptor wraps the calls and the sends of a client, msg is
+// the request of Call or the message of Send. It may change msg or ctx
+// before calling next, e.g. add per call headers by ContextWithHeader,
+// record metrics, or translate the result and the error returned by
+// next. The interceptors see each call once, the retries happen inside
+// next.
+type ClientInterceptor func(ctx context.Context, msg jsoff.Message, next ClientCallFunc) (jsoff.Message, error)
+
+// intercept passes msg through the interceptors, the first one is the
+// outermost, call is the last step
+func intercept(ctx context.Context, interceptors []ClientInterceptor, msg jsoff.Message, call ClientCallFunc) (jsoff.Message, error) {
+	if len(interceptors) == 0 {
+		return call(ctx, msg)
+	}
+	next := func(ctx context.Context, msg jsoff.Message) (jsoff.Message, error) {
+		return intercept(ctx, interceptors[1:], msg, call)
+	}
+	return interceptors[0](ctx, msg, next)
+}
+
+// callStep makes call the last step of the interceptors of Call
+func callStep(call func(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error)) ClientCallFunc {
+	return func(ctx context.Context, msg jsoff.Message) (jsoff.Message, error) {
+		reqmsg, ok := msg.(*jsoff.RequestMessage)
+		if !ok {
+			return nil, errors.New("interceptors must pass a request to the next step of Call")
+		}
+		return call(ctx, reqmsg)
+	}
+}
+
+// sendStep makes send the last step of the interceptors of Send
+func sendStep(send func(ctx context.Context, msg jsoff.Message) error) ClientCallFunc {
+	return func(ctx context.Context, msg jsoff.Message) (jsoff.Message, error) {
+		return nil, send(ctx, msg)
+	}
+}
+
+type headerKeyT struct{}
+
+// ContextWithHeader returns a context, calls and sends with it carry
+// the header in addition to the extra header of the client. Only the
+// http1 client has per request headers, the streaming clients send
+// their headers once when connecting and fail with
+// ErrHeaderNotSupported.
+func ContextWithHeader(ctx context.Context, h http.Header) context.Context {
+	if prev := headerFromContext(ctx); prev != nil {
+		merged := prev.Clone()
+		for k, vs := range h {
+			for _, v := range vs {
+				merged.Add(k, v)
+			}
+		}
+		h = merged
+	}
+	return context.WithValue(ctx, headerKeyT{}, h)
+}
+
+func headerFromContext(ctx context.Context) http.Header {
+	if h, ok := ctx.Value(headerKeyT{}).(http.Header); ok {
+		return h
+	}
+	return nil
+}
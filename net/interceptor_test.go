@@ -0,0 +1,91 @@
+package jsoffnet
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+func TestClientInterceptors(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewGatewayHandler(rootCtx, nil, true)
+	server.Actor.OnRequest("token", func(req *RPCRequest, params []any) (any, error) {
+		if r := req.HttpRequest(); r != nil {
+			return r.Header.Get("X-Token"), nil
+		}
+		return "", nil
+	})
+	server.Actor.On("echo", func(params []any) (any, error) {
+		return params[0], nil
+	})
+	go ListenAndServe(rootCtx, "127.0.0.1:28025", server)
+	time.Sleep(10 * time.Millisecond)
+
+	var calls atomic.Int32
+	errDenied := errors.New("denied")
+	opts := ClientOptions{
+		Interceptors: []ClientInterceptor{
+			// record metrics
+			func(ctx context.Context, msg jsoff.Message, next ClientCallFunc) (jsoff.Message, error) {
+				calls.Add(1)
+				return next(ctx, msg)
+			},
+			// per call headers and error translation
+			func(ctx context.Context, msg jsoff.Message, next ClientCallFunc) (jsoff.Message, error) {
+				if msg.IsRequest() && msg.MustMethod() == "token" {
+					ctx = ContextWithHeader(ctx, http.Header{"X-Token": []string{"t1"}})
+				}
+				resmsg, err := next(ctx, msg)
+				if err == nil && resmsg != nil && resmsg.IsError() {
+					return resmsg, errDenied
+				}
+				return resmsg, err
+			},
+			// mutate the request
+			func(ctx context.Context, msg jsoff.Message, next ClientCallFunc) (jsoff.Message, error) {
+				if reqmsg, ok := msg.(*jsoff.RequestMessage); ok && reqmsg.Method == "echo" {
+					reqmsg.Params = []any{"intercepted"}
+				}
+				return next(ctx, msg)
+			},
+		},
+	}
+
+	for _, serverUrl := range []string{"http://127.0.0.1:28025", "ws://127.0.0.1:28025"} {
+		calls.Store(0)
+		client, err := NewClient(serverUrl, opts)
+		assert.Nil(err)
+
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "echo", []any{"hi"}))
+		assert.Nil(err)
+		assert.Equal("intercepted", resmsg.MustResult())
+
+		_, err = client.Call(rootCtx, jsoff.NewRequestMessage(2, "nosuchmethod", nil))
+		assert.ErrorIs(err, errDenied)
+
+		// the streaming clients have no per call headers
+		resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(3, "token", nil))
+		if client.IsStreaming() {
+			assert.ErrorIs(err, ErrHeaderNotSupported)
+			assert.Error(client.Send(ContextWithHeader(rootCtx, http.Header{"X-Token": []string{"t1"}}), jsoff.NewNotifyMessage("echo", []any{"hi"})))
+		} else {
+			assert.Nil(err)
+			assert.Equal("t1", resmsg.MustResult())
+			assert.Nil(client.Send(ContextWithHeader(rootCtx, http.Header{"X-Token": []string{"t1"}}), jsoff.NewNotifyMessage("echo", []any{"hi"})))
+		}
+
+		// sends pass the interceptors too
+		assert.Nil(client.Send(rootCtx, jsoff.NewNotifyMessage("echo", []any{"hi"})))
+		assert.Equal(int32(5), calls.Load(), serverUrl)
+	}
+}
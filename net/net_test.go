@@ -2,9 +2,11 @@ package jsoffnet
 
 import (
 	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/superisaac/jsoff"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 )
@@ -55,3 +57,508 @@ func TestTCPServerClient(t *testing.T) {
 	res := resmsg.MustResult()
 	assert.Equal("hello102", res)
 }
+
+func TestTCPSessionCallClosed(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	callErrs := make(chan error, 1)
+	server := NewTCPServer(context.Background(), nil)
+	server.Actor.OnRequest("hangup", func(req *RPCRequest, params []any) (any, error) {
+		go func() {
+			reqmsg := jsoff.NewRequestMessage(jsoff.NewUuid(), "neverAnswer", nil)
+			_, err := req.Session().Call(context.Background(), reqmsg)
+			callErrs <- err
+		}()
+		return "ok", nil
+	})
+
+	go server.Start(rootCtx, "127.0.0.1:21801")
+	defer server.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21801"))
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "hangup", nil))
+	assert.Nil(err)
+	assert.Equal("ok", resmsg.MustResult())
+
+	// the pending call fails once the session is closed
+	client.Close()
+	select {
+	case err := <-callErrs:
+		assert.ErrorIs(err, TransportClosed)
+	case <-time.After(time.Second):
+		assert.Fail("session call is not cleaned up")
+	}
+}
+
+func TestTCPCancelRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cancelled := make(chan error, 1)
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.OnContext("slow", func(ctx context.Context, params []any) (any, error) {
+		select {
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return "done", nil
+		}
+	})
+
+	go server.Start(rootCtx, "127.0.0.1:21802")
+	defer server.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21802"))
+
+	callCtx, cancelCall := context.WithCancel(rootCtx)
+	time.AfterFunc(50*time.Millisecond, cancelCall)
+	reqmsg := jsoff.NewRequestMessage(1, "slow", nil)
+	_, err := client.Call(callCtx, reqmsg)
+	assert.ErrorIs(err, context.Canceled)
+
+	// the server handler is cancelled by $/cancelRequest
+	select {
+	case err := <-cancelled:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(time.Second):
+		assert.Fail("server handler not cancelled")
+	}
+}
+
+func TestTCPShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.OnTyped("sleep", func(ms int) (string, error) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return "awake", nil
+	})
+
+	go server.Start(rootCtx, "127.0.0.1:21803")
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21803"))
+	notified := make(chan jsoff.Message, 10)
+	client.OnMessage(func(msg jsoff.Message) {
+		notified <- msg
+	})
+
+	results := make(chan jsoff.Message, 1)
+	go func() {
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "sleep", []any{100}))
+		assert.Nil(err)
+		results <- resmsg
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the in-flight request is replied before the session ends
+	shutdownCtx, cancelShutdown := context.WithTimeout(rootCtx, 2*time.Second)
+	defer cancelShutdown()
+	assert.Nil(server.Shutdown(shutdownCtx))
+	assert.Equal(ShutdownMethod, (<-notified).MustMethod())
+	assert.Equal("awake", (<-results).MustResult())
+	assert.Equal(0, len(server.Sessions.List()))
+
+	// new connections are refused
+	client1 := NewTCPClient(urlParse("tcp://127.0.0.1:21803"))
+	_, err := client1.Call(rootCtx, jsoff.NewRequestMessage(2, "sleep", []any{1}))
+	assert.NotNil(err)
+}
+
+func TestTCPServerCtxDone(t *testing.T) {
+	assert := assert.New(t)
+
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	defer cancelServer()
+
+	server := NewTCPServer(serverCtx, nil)
+	server.Actor.On("slow", func(params []any) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return "done", nil
+	})
+	go server.Start(serverCtx, "127.0.0.1:21812")
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21812"))
+	defer client.Close()
+
+	// the in-flight call is answered before the session ends
+	time.AfterFunc(50*time.Millisecond, cancelServer)
+	resmsg, err := client.Call(context.Background(), jsoff.NewRequestMessage(1, "slow", nil))
+	if assert.Nil(err) {
+		assert.Equal("done", resmsg.MustResult())
+	}
+}
+
+func TestTCPShutdownDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.OnTyped("sleep", func(ms int) (string, error) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return "awake", nil
+	})
+
+	go server.Start(rootCtx, "127.0.0.1:21804")
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21804"))
+	closed := make(chan bool, 1)
+	client.OnClose(func() {
+		closed <- true
+	})
+	go client.Call(rootCtx, jsoff.NewRequestMessage(1, "sleep", []any{2000}))
+	time.Sleep(20 * time.Millisecond)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(rootCtx, 50*time.Millisecond)
+	defer cancelShutdown()
+	assert.ErrorIs(server.Shutdown(shutdownCtx), context.DeadlineExceeded)
+	// the session is closed forcibly
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		assert.Fail("client not closed")
+	}
+}
+
+func TestTCPDeadlinePropagation(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deadlines := make(chan time.Duration, 1)
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.OnContext("deadline", func(ctx context.Context, params []any) (any, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(ok)
+		deadlines <- time.Until(deadline)
+		return "ok", nil
+	})
+
+	go server.Start(rootCtx, "127.0.0.1:21805")
+	defer server.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21805"))
+	callCtx, cancelCall := context.WithTimeout(rootCtx, 2*time.Second)
+	defer cancelCall()
+	resmsg, err := client.Call(callCtx, jsoff.NewRequestMessage(1, "deadline", nil))
+	assert.Nil(err)
+	assert.Equal("ok", resmsg.MustResult())
+	left := <-deadlines
+	assert.True(left > time.Second && left <= 2*time.Second)
+}
+
+func TestTCPReconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sinks := make(chan *SubscriptionSink, 10)
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.OnSubscribe("tick_subscribe", func(ctx context.Context, params []any, sink *SubscriptionSink) error {
+		sinks <- sink
+		return nil
+	})
+	server.Actor.OnTyped("sleep", func(ms int) (string, error) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return "awake", nil
+	})
+
+	go server.Start(rootCtx, "127.0.0.1:21806")
+	defer server.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21806"), ClientOptions{
+		Reconnect: ReconnectOptions{
+			Enabled:      true,
+			InitialDelay: 10 * time.Millisecond,
+		},
+	})
+	defer client.Close()
+	reconnected := make(chan bool, 1)
+	client.OnReconnect(func() {
+		reconnected <- true
+	})
+
+	ch, _, err := client.Subscribe(rootCtx, "tick_subscribe", nil)
+	assert.Nil(err)
+	sink := <-sinks
+	sink.Notify(1)
+	assert.Equal("tick_subscription", (<-ch).MustMethod())
+
+	// the pending call fails when the server drops the session
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "sleep", []any{500}))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.True(server.Sessions.Close(sink.Session().SessionID(), "bye"))
+	select {
+	case err := <-done:
+		assert.ErrorIs(err, TransportClosed)
+	case <-time.After(time.Second):
+		assert.Fail("pending call not failed")
+	}
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		assert.Fail("client not reconnected")
+	}
+
+	// the subscription is replayed on the new session
+	sink1 := <-sinks
+	assert.NotEqual(sink.ID(), sink1.ID())
+	sink1.Notify(2)
+	ntfmsg := <-ch
+	m, _ := ntfmsg.MustParams()[0].(map[string]any)
+	assert.Equal(sink1.ID(), m["subscription"])
+
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(2, "sleep", []any{1}))
+	assert.Nil(err)
+	assert.Equal("awake", resmsg.MustResult())
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := ReconnectOptions{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for i := 0; i < 20; i++ {
+		d := opts.Backoff(1)
+		assert.True(d >= 50*time.Millisecond && d <= 100*time.Millisecond)
+		d = opts.Backoff(3)
+		assert.True(d >= 200*time.Millisecond && d <= 400*time.Millisecond)
+		d = opts.Backoff(10)
+		assert.True(d >= 500*time.Millisecond && d <= time.Second)
+	}
+}
+
+func TestTCPConcurrentDisconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.OnTyped("sleep", func(ms int) (string, error) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return "awake", nil
+	})
+
+	go server.Start(rootCtx, "127.0.0.1:21807")
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21807"))
+	err := client.Connect(rootCtx)
+	assert.Nil(err)
+	closeChannel := client.CloseChannel()
+
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			_, err := client.Call(rootCtx, jsoff.NewRequestMessage(i, "sleep", []any{500}))
+			errs <- err
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// all pending calls fail when the session drops
+	sessions := server.Sessions.List()
+	assert.Equal(1, len(sessions))
+	server.Sessions.Close(sessions[0].SessionID(), "bye")
+	for i := 0; i < 20; i++ {
+		select {
+		case err := <-errs:
+			assert.ErrorIs(err, TransportClosed)
+		case <-time.After(time.Second):
+			assert.Fail("pending call not failed")
+		}
+	}
+	assert.Equal(TransportClosed, <-closeChannel)
+	assert.False(client.Connected())
+
+	// Send fails instead of blocking when the server is gone
+	server.Stop()
+	time.Sleep(10 * time.Millisecond)
+	sendCtx, cancelSend := context.WithTimeout(rootCtx, time.Second)
+	defer cancelSend()
+	err = client.Send(sendCtx, jsoff.NewNotifyMessage("hello", nil))
+	assert.ErrorIs(err, TransportConnectFailed)
+}
+
+func TestTCPRetryConnect(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.On("echo", func(params []any) (any, error) {
+		return params[0], nil
+	})
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21808"), ClientOptions{
+		Retry: RetryPolicy{
+			MaxAttempts:       10,
+			InitialDelay:      50 * time.Millisecond,
+			MaxDelay:          100 * time.Millisecond,
+			IdempotentMethods: []string{"*"},
+		},
+	})
+
+	// the server starts after the first attempt is refused
+	time.AfterFunc(100*time.Millisecond, func() {
+		go server.Start(rootCtx, "127.0.0.1:21808")
+	})
+	defer server.Stop()
+
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "echo", []any{"hi"}))
+	assert.Nil(err)
+	assert.Equal("hi", resmsg.MustResult())
+}
+
+func TestTCPCallContextCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.On("echo", func(params []any) (any, error) {
+		return params[0], nil
+	})
+	go server.Start(rootCtx, "127.0.0.1:21809")
+	defer server.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21809"))
+	defer client.Close()
+
+	// the call connecting the client is done
+	callCtx, cancelCall := context.WithTimeout(rootCtx, time.Second)
+	resmsg, err := client.Call(callCtx, jsoff.NewRequestMessage(1, "echo", []any{"hi"}))
+	cancelCall()
+	assert.Nil(err)
+	assert.Equal("hi", resmsg.MustResult())
+	time.Sleep(20 * time.Millisecond)
+
+	// the connection is kept for other calls
+	assert.True(client.Connected())
+	resmsg, err = client.Call(rootCtx, jsoff.NewRequestMessage(2, "echo", []any{"again"}))
+	assert.Nil(err)
+	assert.Equal("again", resmsg.MustResult())
+}
+
+func TestTCPConcurrentSameId(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewTCPServer(rootCtx, nil)
+	server.Actor.On("echo", func(params []any) (any, error) {
+		time.Sleep(10 * time.Millisecond)
+		return params[0], nil
+	})
+	go server.Start(rootCtx, "127.0.0.1:21810")
+	defer server.Stop()
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21810"))
+	defer client.Close()
+
+	// the calls share the id, each gets its own result
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "echo", []any{i}))
+			assert.Nil(err)
+			assert.Equal(1, resmsg.MustId())
+			assert.Equal(fmt.Sprint(i), fmt.Sprint(resmsg.MustResult()))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestTCPCloseWhileReconnecting(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewTCPServer(rootCtx, nil)
+	go server.Start(rootCtx, "127.0.0.1:21811")
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewTCPClient(urlParse("tcp://127.0.0.1:21811"), ClientOptions{
+		Reconnect: ReconnectOptions{
+			Enabled:      true,
+			InitialDelay: 10 * time.Second,
+			MaxDelay:     30 * time.Second,
+		},
+	})
+	err := client.Connect(rootCtx)
+	assert.Nil(err)
+	time.Sleep(20 * time.Millisecond)
+
+	// the server goes away and the client backs off
+	server.Stop()
+	for _, session := range server.Sessions.List() {
+		server.Sessions.Close(session.SessionID(), "bye")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.True(client.reconnecting.Load())
+
+	// Close wakes up the backoff
+	client.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.False(client.reconnecting.Load())
+	assert.False(client.Connected())
+}
+
+func TestSessionCallNilId(t *testing.T) {
+	assert := assert.New(t)
+
+	caller := newSessionCaller()
+	sent := make(chan jsoff.Message, 1)
+	send := func(msg jsoff.Message) {
+		sent <- msg
+		if msg.IsRequest() {
+			caller.handleResult(jsoff.NewResultMessage(msg.(*jsoff.RequestMessage), "ok"))
+		}
+	}
+	resmsg, err := caller.call(context.Background(), send, jsoff.NewRequestMessage(nil, "hello", nil))
+	assert.Nil(err)
+	assert.Equal("ok", resmsg.MustResult())
+	assert.Nil(resmsg.MustId())
+	// sent with a new id
+	assert.NotNil((<-sent).MustId())
+
+	// no pending entry is left behind
+	left := 0
+	caller.pendings.Range(func(k, v any) bool {
+		left++
+		return true
+	})
+	assert.Equal(0, left)
+}
@@ -0,0 +1,93 @@
+package jsoffnet
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultReconnectInitialDelay = 100 * time.Millisecond
+	defaultReconnectMaxDelay     = 30 * time.Second
+)
+
+// Backoff returns the delay before the attempt-th reconnecting
+// attempt, starting from 1
+func (opts ReconnectOptions) Backoff(attempt int) time.Duration {
+	initial := opts.InitialDelay
+	if initial <= 0 {
+		initial = defaultReconnectInitialDelay
+	}
+	maxDelay := opts.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultReconnectMaxDelay
+	}
+	return expBackoff(initial, maxDelay, attempt)
+}
+
+// reconnect connects the server again according to the reconnect
+// policy, the client closes with cause if all attempts fail
+func (client *StreamingClient) reconnect(cause error) {
+	opts := client.clientOptions.Reconnect
+	client.stateLock.Lock()
+	closeSignal := client.closeSignalChannel()
+	client.stateLock.Unlock()
+	for attempt := 1; opts.MaxAttempts <= 0 || attempt <= opts.MaxAttempts; attempt++ {
+		// Close wakes up the backoff
+		timer := time.NewTimer(opts.Backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-closeSignal:
+			timer.Stop()
+		}
+		if client.closeRequested.Load() {
+			client.reconnecting.Store(false)
+			client.closeSubscriptions()
+			return
+		}
+		err := client.connect(context.Background())
+		if err != nil {
+			client.Log().Warnf("reconnect attempt %d failed, %s", attempt, err)
+			continue
+		}
+		client.Log().Infof("reconnected after %d attempts", attempt)
+		client.reconnecting.Store(false)
+		client.resubscribe()
+		if client.reconnectHandler != nil {
+			client.reconnectHandler()
+		}
+		return
+	}
+	client.Log().Warnf("give up reconnecting after %d attempts", opts.MaxAttempts)
+	client.reconnecting.Store(false)
+	client.Reset(cause)
+	client.closeSubscriptions()
+}
+
+// resubscribe replays the subscriptions on the new connection, the
+// subscriptions failed to replay are closed
+func (client *StreamingClient) resubscribe() {
+	client.subLock.Lock()
+	subs := make([]*clientSubscription, 0, len(client.subscriptions))
+	for _, sub := range client.subscriptions {
+		subs = append(subs, sub)
+	}
+	client.subLock.Unlock()
+
+	for _, sub := range subs {
+		ctx, cancel := context.WithTimeout(context.Background(), client.callTimeout())
+		err := client.subscribe(ctx, sub)
+		cancel()
+		if err == nil || errors.Is(err, ErrSubscriptionClosed) {
+			continue
+		}
+		client.Log().Warnf("replay subscription %s error %s", sub.method, err)
+		client.subLock.Lock()
+		if client.subscriptions[sub.id] == sub {
+			delete(client.subscriptions, sub.id)
+			close(sub.ch)
+		}
+		client.subLock.Unlock()
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/superisaac/jsoff"
 	"github.com/superisaac/jsoff/schema"
 	"net/http"
+	"time"
 )
 
 const (
@@ -17,10 +18,29 @@ const (
 	TransportVsock     = "vsock"
 )
 
+// the notify method to report the progress of a request, in the form
+// of {"method": "$/progress", "params": {"token": <request id>, "value": value}}
+const ProgressMethod = "$/progress"
+
 type RPCSession interface {
 	Context() context.Context
 	Send(msg jsoff.Message)
 	SessionID() string
+
+	// Call sends a request message to the client side and waits
+	// for the result, a timeout Error message is returned when
+	// the context deadline(or DefaultSessionCallTimeout) exceeds.
+	Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error)
+
+	// Values returns the key/value store living with the session
+	Values() *SessionValues
+
+	CreatedAt() time.Time
+	RemoteAddr() string
+
+	// AuthInfo returns the auth info the session authenticated
+	// with, nil if not authenticated
+	AuthInfo() *AuthInfo
 }
 
 // http rpc quest structure
@@ -31,6 +51,7 @@ type RPCRequest struct {
 	r             *http.Request
 	data          any // arbitrary data
 	session       RPCSession
+	receivedAt    time.Time
 }
 
 func NewRPCRequest(ctx context.Context, msg jsoff.Message, transportType string) *RPCRequest {
@@ -38,9 +59,19 @@ func NewRPCRequest(ctx context.Context, msg jsoff.Message, transportType string)
 		context:       ctx,
 		msg:           msg,
 		transportType: transportType,
+		receivedAt:    time.Now(),
 	}
 }
 
+// Deadline returns the deadline the caller is willing to wait until,
+// ok is false if the caller does not tell
+func (req RPCRequest) Deadline() (deadline time.Time, ok bool) {
+	if reqmsg, isReq := req.msg.(*jsoff.RequestMessage); isReq && reqmsg.Timeout > 0 {
+		return req.receivedAt.Add(time.Duration(reqmsg.Timeout) * time.Millisecond), true
+	}
+	return time.Time{}, false
+}
+
 func (req *RPCRequest) WithSession(session RPCSession) *RPCRequest {
 	req.session = session
 	return req
@@ -63,6 +94,19 @@ func (req RPCRequest) Session() RPCSession {
 	return req.session
 }
 
+// Progress sends the progress of the request to the calling session,
+// it is a no-op on http1 or when the message is not a request.
+func (req RPCRequest) Progress(value any) {
+	if req.session == nil || !req.msg.IsRequest() {
+		return
+	}
+	ntfmsg := jsoff.NewNotifyMessage(ProgressMethod, map[string]any{
+		"token": req.msg.MustId(),
+		"value": value,
+	})
+	req.session.Send(ntfmsg)
+}
+
 func (req RPCRequest) HttpRequest() *http.Request {
 	if req.r == nil {
 		panic("Http Request is nil")
@@ -78,6 +122,8 @@ func (req RPCRequest) Log() *log.Entry {
 	remoteAddr := ""
 	if req.r != nil {
 		remoteAddr = req.r.RemoteAddr
+	} else if req.session != nil {
+		remoteAddr = req.session.RemoteAddr()
 	}
 	return req.msg.Log().WithFields(log.Fields{
 		"ttype":      req.transportType,
@@ -95,10 +141,15 @@ type MissingCallback func(req *RPCRequest) (any, error)
 // type CloseCallback func(r *http.Request, session RPCSession)
 type CloseCallback func(session RPCSession)
 
+// ConnectCallback is called when a streaming session opens, r is nil
+// for tcp and vsock sessions, returning an error refuses the session.
+type ConnectCallback func(session RPCSession, r *http.Request) error
+
 // With method handler
 type MethodHandler struct {
 	callback RequestCallback
 	schema   jsoffschema.Schema
+	timeout  time.Duration
 }
 
 type HandlerSetter func(h *MethodHandler)
@@ -109,6 +160,14 @@ func WithSchema(s jsoffschema.Schema) HandlerSetter {
 	}
 }
 
+// WithTimeout sets the handling timeout of a method, which overrides
+// the default timeout of actor
+func WithTimeout(timeout time.Duration) HandlerSetter {
+	return func(h *MethodHandler) {
+		h.timeout = timeout
+	}
+}
+
 func WithSchemaYaml(yamlSchema string) HandlerSetter {
 	builder := jsoffschema.NewSchemaBuilder()
 	s, err := builder.BuildYamlBytes([]byte(yamlSchema))
@@ -130,10 +189,14 @@ func WithSchemaJson(jsonSchema string) HandlerSetter {
 type Actor struct {
 	ValidateSchema   bool
 	RecoverFromPanic bool
-	methodHandlers   map[string]*MethodHandler
-	missingHandler   MissingCallback
-	closeHandler     CloseCallback
-	children         []*Actor
+	// the default handling timeout of methods, 0 means no timeout
+	Timeout        time.Duration
+	methodHandlers map[string]*MethodHandler
+	missingHandler MissingCallback
+	closeHandler   CloseCallback
+	connectHandler ConnectCallback
+	children       []*Actor
+	subscriptions  *subscriptionTable
 }
 
 func NewActor() *Actor {
@@ -143,6 +206,7 @@ func NewActor() *Actor {
 
 		methodHandlers: make(map[string]*MethodHandler),
 		children:       make([]*Actor, 0),
+		subscriptions:  newSubscriptionTable(),
 	}
 	return a
 }
@@ -234,6 +298,31 @@ func (a *Actor) OnMissing(handler MissingCallback) error {
 	return nil
 }
 
+// OnConnect handler is called when a streaming session opens, before
+// any message is processed
+func (a *Actor) OnConnect(handler ConnectCallback) error {
+	if a.connectHandler != nil {
+		return errors.New("connect handler already exist!")
+	}
+	a.connectHandler = handler
+	return nil
+}
+
+// call the connect handlers, the session is refused if any of them
+// returns an error
+func (a *Actor) HandleConnect(session RPCSession, r *http.Request) error {
+	for _, child := range a.children {
+		if err := child.HandleConnect(session, r); err != nil {
+			return err
+		}
+	}
+
+	if a.connectHandler != nil {
+		return a.connectHandler(session, r)
+	}
+	return nil
+}
+
 // OnClose handler is called when the stream beneath the actor is closed
 func (a *Actor) OnClose(handler CloseCallback) error {
 	if a.closeHandler != nil {
@@ -245,6 +334,8 @@ func (a *Actor) OnClose(handler CloseCallback) error {
 
 // call the close handler if possible
 func (a *Actor) HandleClose(session RPCSession) {
+	a.subscriptions.removeSession(session.SessionID())
+
 	// each child have to be called
 	for _, child := range a.children {
 		child.HandleClose(session)
@@ -328,6 +419,11 @@ func (a *Actor) getHandler(method string) (*MethodHandler, bool) {
 	}
 }
 
+// the method answered by actors to health probes unless a handler
+// is registered for it, actors with a missing handler forward it
+// there, e.g. a proxy asks its upstream
+const PingMethod = "rpc.ping"
+
 // give the actor a request message
 func (a *Actor) Feed(req *RPCRequest) (jsoff.Message, error) {
 	msg := req.Msg()
@@ -359,13 +455,26 @@ func (a *Actor) Feed(req *RPCRequest) (jsoff.Message, error) {
 				return nil, errPos
 			}
 		}
-		return a.recoverCallHandler(handler, req, params)
+		if methodSchema, ok := handler.schema.(*jsoffschema.MethodSchema); ok {
+			// omitted trailing params take the schema defaults
+			params = methodSchema.FillDefaults(params)
+		}
+		timeout := a.Timeout
+		if handler.timeout > 0 {
+			timeout = handler.timeout
+		}
+		return a.callWithTimeout(req, timeout, func(req *RPCRequest) (jsoff.Message, error) {
+			return a.recoverCallHandler(handler, req, params)
+		})
 	} else {
 		for _, child := range a.children {
 			if child.Has(msg.MustMethod()) {
 				return child.Feed(req)
 			}
 		}
+		if msg.IsRequest() && msg.MustMethod() == PingMethod && a.missingHandler == nil {
+			return jsoff.NewResultMessage(msg, "pong"), nil
+		}
 		if a.missingHandler != nil {
 			return a.recoverCallMissingHandler(req)
 		} else {
@@ -378,6 +487,61 @@ func (a *Actor) Feed(req *RPCRequest) (jsoff.Message, error) {
 	return nil, nil
 }
 
+// callWithTimeout calls fn under a deadline context, which is the
+// earlier one of the caller deadline and timeout. The timeout error is
+// returned when the deadline exceeds even if fn ignores the context,
+// and fn is skipped if the deadline has already passed.
+func (a *Actor) callWithTimeout(req *RPCRequest, timeout time.Duration, fn func(req *RPCRequest) (jsoff.Message, error)) (jsoff.Message, error) {
+	deadline, hasDeadline := req.Deadline()
+	if timeout > 0 {
+		if d := time.Now().Add(timeout); !hasDeadline || d.Before(deadline) {
+			deadline, hasDeadline = d, true
+		}
+	}
+	if !hasDeadline {
+		return fn(req)
+	}
+	ctx, cancel := context.WithDeadline(req.Context(), deadline)
+	defer cancel()
+	timedReq := *req
+	timedReq.context = ctx
+
+	timeoutResult := func() (jsoff.Message, error) {
+		if reqmsg, ok := req.Msg().(*jsoff.RequestMessage); ok {
+			return jsoff.ErrTimeout.ToMessage(reqmsg), nil
+		}
+		return nil, nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		req.Log().Warnf("deadline passed before handling")
+		return timeoutResult()
+	}
+
+	type callResult struct {
+		resmsg jsoff.Message
+		err    error
+	}
+	resultChannel := make(chan callResult, 1)
+	go func() {
+		resmsg, err := fn(&timedReq)
+		resultChannel <- callResult{resmsg, err}
+	}()
+
+	select {
+	case res := <-resultChannel:
+		return res.resmsg, res.err
+	case <-ctx.Done():
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// cancelled by the caller, the handler decides what
+			// to return
+			res := <-resultChannel
+			return res.resmsg, res.err
+		}
+		req.Log().Warnf("handler deadline exceeded")
+		return timeoutResult()
+	}
+}
+
 func (a Actor) recoverCallHandler(handler *MethodHandler, req *RPCRequest, params []any) (resmsg0 jsoff.Message, err0 error) {
 	if a.RecoverFromPanic {
 		defer func() {
@@ -0,0 +1,113 @@
+package jsoffnet
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/superisaac/jsoff"
+)
+
+const (
+	defaultRetryInitialDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay     = 5 * time.Second
+)
+
+var defaultRetryableStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+}
+
+// expBackoff returns the delay before the attempt-th attempt starting
+// from 1, the delay doubles from initial up to maxDelay and is
+// jittered in [delay/2, delay]
+func expBackoff(initial, maxDelay time.Duration, attempt int) time.Duration {
+	delay := initial
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	initial := policy.InitialDelay
+	if initial <= 0 {
+		initial = defaultRetryInitialDelay
+	}
+	maxDelay := policy.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	return expBackoff(initial, maxDelay, attempt)
+}
+
+// idempotent returns whether method is in the allowlist
+func (policy RetryPolicy) idempotent(method string) bool {
+	for _, m := range policy.IdempotentMethods {
+		if m == "*" || m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable returns whether the result of a call is worth retrying
+func (policy RetryPolicy) retryable(resmsg jsoff.Message, err error) bool {
+	if err == nil {
+		// the server answers timeout error messages
+		return resmsg != nil && resmsg.IsError() && resmsg.MustError().Code == jsoff.ErrTimeout.Code
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && len(policy.RetryableStatus) > 0 {
+		for _, status := range policy.RetryableStatus {
+			if statusErr.StatusCode == status {
+				return true
+			}
+		}
+		return false
+	}
+	return IsRetryable(err)
+}
+
+// withRetry calls request and calls it again with the same request
+// message according to policy, the errors are classified into the
+// error types of jsoffnet
+func withRetry(ctx context.Context, policy RetryPolicy, reqmsg *jsoff.RequestMessage, request func(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error)) (jsoff.Message, error) {
+	if policy.MaxAttempts <= 1 || !policy.idempotent(reqmsg.Method) {
+		resmsg, err := request(ctx, reqmsg)
+		return resmsg, classifyError(err)
+	}
+	// the http1 client clears the trace id of reqmsg
+	traceId := reqmsg.TraceId()
+	for attempt := 1; ; attempt++ {
+		reqmsg.SetTraceId(traceId)
+		resmsg, err := request(ctx, reqmsg)
+		err = classifyError(err)
+		if attempt >= policy.MaxAttempts || !policy.retryable(resmsg, err) {
+			return resmsg, err
+		}
+		delay := policy.backoff(attempt)
+		logger := reqmsg.Log().WithFields(log.Fields{
+			"attempt":      attempt,
+			"max_attempts": policy.MaxAttempts,
+		})
+		if err != nil {
+			logger.Warnf("call failed, retry after %s, %s", delay, err)
+		} else {
+			logger.Warnf("call timeout, retry after %s", delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return resmsg, err
+		}
+	}
+}
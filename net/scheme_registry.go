@@ -0,0 +1,109 @@
+package jsoffnet
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ClientFactory creates a client of the server url, the url scheme
+// is one the factory is registered with
+type ClientFactory func(serverUrl *url.URL, opts ClientOptions) (Client, error)
+
+// ServerFactory serves actor at the bind url until ctx is done, the
+// url scheme is one the factory is registered with, tlsConfig is nil
+// if not given
+type ServerFactory func(ctx context.Context, bindUrl *url.URL, actor *Actor, tlsConfig *TLSConfig) error
+
+var (
+	schemeLock     sync.RWMutex
+	clientSchemes  = map[string]ClientFactory{}
+	serverSchemes  = map[string]ServerFactory{}
+	errUnsupported = errors.New("url scheme not supported")
+)
+
+// RegisterClientScheme makes NewClient create the clients of scheme
+// by factory, it replaces the factory registered before. The built-in
+// transports register http, https, ws, wss, h2, h2c, tcp and vsock.
+//
+// A streaming transport usually implements the Transport interface
+// and creates its client by embedding StreamingClient and calling
+// InitStreaming with the transport, see TCPClient for example. Other
+// clients must assign ids to the requests with nil ids, see
+// Client.Call.
+func RegisterClientScheme(scheme string, factory ClientFactory) {
+	schemeLock.Lock()
+	defer schemeLock.Unlock()
+	clientSchemes[scheme] = factory
+}
+
+// RegisterServerScheme makes Serve serve the bind urls of scheme by
+// factory, it replaces the factory registered before. The built-in
+// transports register http, https, ws, wss, h2, h2c, tcp and vsock.
+func RegisterServerScheme(scheme string, factory ServerFactory) {
+	schemeLock.Lock()
+	defer schemeLock.Unlock()
+	serverSchemes[scheme] = factory
+}
+
+// ClientSchemes returns the registered client url schemes in order
+func ClientSchemes() []string {
+	schemeLock.RLock()
+	defer schemeLock.RUnlock()
+	return sortedKeys(clientSchemes)
+}
+
+// ServerSchemes returns the registered server url schemes in order
+func ServerSchemes() []string {
+	schemeLock.RLock()
+	defer schemeLock.RUnlock()
+	return sortedKeys(serverSchemes)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func clientFactory(scheme string) (ClientFactory, bool) {
+	schemeLock.RLock()
+	defer schemeLock.RUnlock()
+	factory, ok := clientSchemes[scheme]
+	return factory, ok
+}
+
+func serverFactory(scheme string) (ServerFactory, bool) {
+	schemeLock.RLock()
+	defer schemeLock.RUnlock()
+	factory, ok := serverSchemes[scheme]
+	return factory, ok
+}
+
+// Serve serves actor at the bind url, e.g. "http://127.0.0.1:8000"
+// or "tcp://127.0.0.1:9000", with the server registered for the url
+// scheme until ctx is done.
+func Serve(ctx context.Context, bindUrl string, actor *Actor, tlsConfigs ...*TLSConfig) error {
+	u, err := url.Parse(bindUrl)
+	if err != nil {
+		return errors.Wrap(err, "url.Parse")
+	}
+	factory, ok := serverFactory(u.Scheme)
+	if !ok {
+		return errors.Wrapf(errUnsupported, "scheme %s", u.Scheme)
+	}
+	var tlsConfig *TLSConfig
+	for _, cfg := range tlsConfigs {
+		if cfg != nil {
+			tlsConfig = cfg
+			break
+		}
+	}
+	return factory(ctx, u, actor, tlsConfig)
+}
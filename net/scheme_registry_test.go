@@ -0,0 +1,113 @@
+package jsoffnet
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+)
+
+// a transport of tcp with its own name
+type inhouseTransport struct {
+	Transport
+}
+
+func (t inhouseTransport) Type() string {
+	return "inhouse"
+}
+
+func TestSchemeRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, scheme := range []string{"http", "https", "ws", "wss", "h2", "h2c", "tcp", "vsock"} {
+		assert.Contains(ClientSchemes(), scheme)
+		assert.Contains(ServerSchemes(), scheme)
+	}
+
+	_, err := NewClient("nosuchscheme://127.0.0.1:28029")
+	assert.NotNil(err)
+	err = Serve(context.Background(), "nosuchscheme://127.0.0.1:28029", nil)
+	assert.NotNil(err)
+
+	// an in-house scheme carried by tcp
+	t.Cleanup(func() {
+		schemeLock.Lock()
+		defer schemeLock.Unlock()
+		delete(clientSchemes, "inhouse")
+		delete(serverSchemes, "inhouse")
+	})
+	RegisterClientScheme("inhouse", func(serverUrl *url.URL, opts ClientOptions) (Client, error) {
+		u := *serverUrl
+		u.Scheme = "tcp"
+		c := NewTCPClient(&u, opts)
+		c.InitStreaming(&u, inhouseTransport{c.transport}, opts)
+		return c, nil
+	})
+	RegisterServerScheme("inhouse", func(ctx context.Context, bindUrl *url.URL, actor *Actor, tlsConfig *TLSConfig) error {
+		u := *bindUrl
+		u.Scheme = "tcp"
+		return Serve(ctx, u.String(), actor, tlsConfig)
+	})
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	actor := NewActor()
+	actor.On("echo", func(params []any) (any, error) {
+		return params[0], nil
+	})
+	// asks the transport the client actor sees
+	actor.OnRequest("clientTransport", func(req *RPCRequest, params []any) (any, error) {
+		resmsg, err := req.Session().Call(req.Context(), jsoff.NewRequestMessage(jsoff.NewUuid(), "transport", nil))
+		if err != nil {
+			return nil, err
+		}
+		return resmsg.MustResult(), nil
+	})
+	served := make(chan error, 2)
+	for _, bindUrl := range []string{"http://127.0.0.1:28029", "inhouse://127.0.0.1:28030"} {
+		go func(bindUrl string) {
+			served <- Serve(rootCtx, bindUrl, actor)
+		}(bindUrl)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for _, serverUrl := range []string{"http://127.0.0.1:28029", "ws://127.0.0.1:28029", "inhouse://127.0.0.1:28030"} {
+		client, err := NewClient(serverUrl)
+		assert.Nil(err)
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "echo", []any{"hi"}))
+		assert.Nil(err, serverUrl)
+		assert.Equal("hi", resmsg.MustResult())
+		if sc, ok := client.(interface{ Close() }); ok {
+			sc.Close()
+		}
+	}
+
+	// the client actor sees the type of the transport
+	client, err := NewClient("inhouse://127.0.0.1:28030")
+	assert.Nil(err)
+	sc := client.(*TCPClient)
+	defer sc.Close()
+	clientActor := NewActor()
+	clientActor.OnRequest("transport", func(req *RPCRequest, params []any) (any, error) {
+		return req.transportType, nil
+	})
+	sc.SetActor(clientActor)
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(2, "clientTransport", nil))
+	if assert.Nil(err) {
+		assert.Equal("inhouse", resmsg.MustResult())
+	}
+
+	// the servers stop when the context is done
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-served:
+		case <-time.After(time.Second):
+			assert.Fail("server not stopped")
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"net"
 	"net/http"
+	"time"
 )
 
 type TLSConfig struct {
@@ -23,6 +24,34 @@ func (cfg *TLSConfig) ValidateValues() error {
 	return nil
 }
 
+// the longest time ListenAndServe waits for in-flight requests after
+// the context is done
+const DefaultShutdownTimeout = 10 * time.Second
+
+// handlers that can be gracefully shut down
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdownOnDone shuts down sd gracefully once ctx is done, call
+// the returned stop to give up waiting
+func shutdownOnDone(ctx context.Context, sd shutdowner) (stop func()) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-watchCtx.Done()
+		if ctx.Err() == nil {
+			// stopped
+			return
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+		if err := sd.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("server shutdown error %s", err)
+		}
+	}()
+	return cancel
+}
+
 func ListenAndServe(rootCtx context.Context, bind string, handler http.Handler, tlsConfigs ...*TLSConfig) error {
 	var tlsConfig *TLSConfig
 	for _, cfg := range tlsConfigs {
@@ -41,10 +70,17 @@ func ListenAndServe(rootCtx context.Context, bind string, handler http.Handler,
 	defer cancelServer()
 
 	go func() {
-		for {
-			<-serverCtx.Done()
-			listener.Close()
-			return
+		<-serverCtx.Done()
+		// stop accepting and let the in-flight requests finish
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+		if sd, ok := handler.(shutdowner); ok {
+			if err := sd.Shutdown(shutdownCtx); err != nil {
+				log.Warnf("handler shutdown error %s", err)
+			}
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("server shutdown error %s", err)
 		}
 	}()
 
@@ -0,0 +1,328 @@
+package jsoffnet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superisaac/jsoff"
+)
+
+// the default timeout of a session call whose context has no deadline
+const DefaultSessionCallTimeout = 10 * time.Second
+
+type sessionPending struct {
+	reqmsg        *jsoff.RequestMessage
+	resultChannel chan jsoff.Message
+}
+
+// sessionCaller tracks the requests a server session sends to the
+// client side, and routes the result messages back to the callers.
+type sessionCaller struct {
+	pendings  sync.Map
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSessionCaller() *sessionCaller {
+	return &sessionCaller{
+		closed: make(chan struct{}),
+	}
+}
+
+// call sends reqmsg through send and waits until the result comes,
+// the context deadline exceeds or the session closes.
+func (c *sessionCaller) call(ctx context.Context, send func(msg jsoff.Message), reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	select {
+	case <-c.closed:
+		return nil, TransportClosed
+	default:
+	}
+
+	p := &sessionPending{
+		reqmsg:        reqmsg,
+		resultChannel: make(chan jsoff.Message, 1),
+	}
+	// a nil id can't be told from the null id of error messages,
+	// and a pending id is taken, both call with a new id
+	sendmsg := reqmsg
+	if reqmsg.Id == nil {
+		sendmsg = reqmsg.Clone(jsoff.NewUuid())
+	}
+	for {
+		if _, loaded := c.pendings.LoadOrStore(sendmsg.Id, p); !loaded {
+			break
+		}
+		sendmsg = reqmsg.Clone(jsoff.NewUuid())
+	}
+	defer c.pendings.Delete(sendmsg.Id)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, DefaultSessionCallTimeout)
+		defer cancel()
+	}
+
+	send(sendmsg)
+
+	select {
+	case resmsg := <-p.resultChannel:
+		if sendmsg != reqmsg {
+			resmsg = resmsg.ReplaceId(reqmsg.Id)
+		}
+		return resmsg, nil
+	case <-c.closed:
+		return nil, TransportClosed
+	case <-ctx.Done():
+		// tell the client to stop handling the request
+		send(newCancelMessage(sendmsg.Id))
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return jsoff.ErrTimeout.ToMessage(reqmsg), nil
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// handleResult delivers a result message to the pending call,
+// returns false if no call is waiting for it.
+func (c *sessionCaller) handleResult(msg jsoff.Message) bool {
+	v, loaded := c.pendings.LoadAndDelete(msg.MustId())
+	if !loaded {
+		return false
+	}
+	if pending, ok := v.(*sessionPending); ok {
+		select {
+		case pending.resultChannel <- msg:
+		default:
+		}
+	}
+	return true
+}
+
+// close fails all pending calls with TransportClosed
+func (c *sessionCaller) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
+
+// the notify method to cancel an in-flight request, in the form of
+// {"method": "$/cancelRequest", "params": {"id": <request id>}}
+const CancelRequestMethod = "$/cancelRequest"
+
+func newCancelMessage(id any) jsoff.Message {
+	return jsoff.NewNotifyMessage(CancelRequestMethod, map[string]any{"id": id})
+}
+
+// inflightKey makes ids comparable regardless of their decoded go
+// types, e.g. 1 and json.Number("1")
+func inflightKey(id any) string {
+	data, err := json.Marshal(id)
+	if err != nil {
+		return fmt.Sprintf("%v", id)
+	}
+	return string(data)
+}
+
+// inflightRequests tracks the messages being handled, the contexts
+// of requests can be cancelled by a $/cancelRequest notify
+type inflightRequests struct {
+	cancels sync.Map
+
+	lock   sync.Mutex
+	active int
+	idle   chan struct{}
+}
+
+// begin returns the context to handle msg, done must be called after
+// msg is handled
+func (t *inflightRequests) begin(ctx context.Context, msg jsoff.Message) (context.Context, func()) {
+	t.enter()
+	if !msg.IsRequest() {
+		return ctx, t.leave
+	}
+	key := inflightKey(msg.MustId())
+	ctx, cancel := context.WithCancel(ctx)
+	t.cancels.Store(key, cancel)
+	return ctx, func() {
+		t.cancels.Delete(key)
+		cancel()
+		t.leave()
+	}
+}
+
+func (t *inflightRequests) enter() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.active++
+}
+
+func (t *inflightRequests) leave() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.active--
+	if t.active == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// waitIdle waits until no message is being handled
+func (t *inflightRequests) waitIdle(ctx context.Context) error {
+	t.lock.Lock()
+	if t.active == 0 {
+		t.lock.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.lock.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleCancel cancels the in-flight request if msg is a
+// $/cancelRequest notify, returns false if msg is not such a notify
+func (t *inflightRequests) handleCancel(msg jsoff.Message) bool {
+	if !msg.IsNotify() || msg.MustMethod() != CancelRequestMethod {
+		return false
+	}
+	params := msg.MustParams()
+	if len(params) < 1 {
+		return true
+	}
+	id := params[0]
+	if m, ok := id.(map[string]any); ok {
+		id = m["id"]
+	}
+	if v, loaded := t.cancels.LoadAndDelete(inflightKey(id)); loaded {
+		msg.Log().Debugf("request %v cancelled", id)
+		v.(context.CancelFunc)()
+	}
+	return true
+}
+
+// cancelAll cancels all in-flight requests
+func (t *inflightRequests) cancelAll() {
+	t.cancels.Range(func(k, v any) bool {
+		t.cancels.Delete(k)
+		v.(context.CancelFunc)()
+		return true
+	})
+}
+
+// SessionValues is a concurrency-safe key/value store living with a
+// session
+type SessionValues struct {
+	m sync.Map
+}
+
+func (v *SessionValues) Get(key string) (any, bool) {
+	return v.m.Load(key)
+}
+
+func (v *SessionValues) Set(key string, value any) {
+	v.m.Store(key, value)
+}
+
+func (v *SessionValues) Delete(key string) {
+	v.m.Delete(key)
+}
+
+func (v *SessionValues) Range(f func(key string, value any) bool) {
+	v.m.Range(func(k, value any) bool {
+		return f(k.(string), value)
+	})
+}
+
+// sessionMeta holds the metadata of a session, it is embedded into
+// the session types
+type sessionMeta struct {
+	createdAt  time.Time
+	remoteAddr string
+	authInfo   *AuthInfo
+	values     *SessionValues
+}
+
+func newSessionMeta(remoteAddr string, authInfo *AuthInfo) sessionMeta {
+	return sessionMeta{
+		createdAt:  time.Now(),
+		remoteAddr: remoteAddr,
+		authInfo:   authInfo,
+		values:     &SessionValues{},
+	}
+}
+
+func newHTTPSessionMeta(r *http.Request) sessionMeta {
+	authInfo, _ := AuthInfoFromContext(r.Context())
+	return newSessionMeta(r.RemoteAddr, authInfo)
+}
+
+func (meta sessionMeta) CreatedAt() time.Time {
+	return meta.createdAt
+}
+
+func (meta sessionMeta) RemoteAddr() string {
+	return meta.remoteAddr
+}
+
+func (meta sessionMeta) AuthInfo() *AuthInfo {
+	return meta.authInfo
+}
+
+func (meta sessionMeta) Values() *SessionValues {
+	return meta.values
+}
+
+// the notify method sent to clients when the server is shutting down
+const ShutdownMethod = "rpc.shutdown"
+
+// shutdownSession tells the client the server is going away, waits
+// for the handlers to finish and then ends the session after the
+// pending messages are written, the session is closed forcibly when
+// ctx is done.
+// runQueue runs the queued funcs one by one until ctx is done,
+// sequential sessions feed their messages in it so that the read loop
+// keeps reading the results of Call and $/cancelRequest meanwhile
+func runQueue(ctx context.Context, queue chan func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-queue:
+			f()
+		}
+	}
+}
+
+func shutdownSession(ctx context.Context, session registeredSession, sendChannel chan jsoff.Message, inflight *inflightRequests, caller *sessionCaller) error {
+	select {
+	case sendChannel <- jsoff.NewNotifyMessage(ShutdownMethod, nil):
+	case <-ctx.Done():
+	}
+	if err := inflight.waitIdle(ctx); err == nil {
+		// a nil message tells sendLoop to end the session
+		select {
+		case sendChannel <- nil:
+		case <-ctx.Done():
+		}
+	}
+	select {
+	case <-caller.closed:
+		return nil
+	case <-ctx.Done():
+		session.close("shutdown deadline exceeded")
+		return ctx.Err()
+	}
+}
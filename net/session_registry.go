@@ -0,0 +1,111 @@
+package jsoffnet
+
+import (
+	"context"
+	"sync"
+
+	"github.com/superisaac/jsoff"
+)
+
+// the sessions created by servers, which can be closed by the
+// registry
+type registeredSession interface {
+	RPCSession
+	close(reason string)
+	shutdown(ctx context.Context) error
+
+	// the server creating the session
+	owner() any
+}
+
+// SessionRegistry tracks the live sessions of servers, a registry
+// can be shared among servers
+type SessionRegistry struct {
+	sessions sync.Map
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{}
+}
+
+func (reg *SessionRegistry) add(session registeredSession) {
+	if reg != nil {
+		reg.sessions.Store(session.SessionID(), session)
+	}
+}
+
+func (reg *SessionRegistry) remove(session registeredSession) {
+	if reg != nil {
+		reg.sessions.Delete(session.SessionID())
+	}
+}
+
+// List returns the live sessions
+func (reg *SessionRegistry) List() []RPCSession {
+	sessions := []RPCSession{}
+	reg.sessions.Range(func(k, v any) bool {
+		sessions = append(sessions, v.(RPCSession))
+		return true
+	})
+	return sessions
+}
+
+// Get returns the session of id
+func (reg *SessionRegistry) Get(id string) (RPCSession, bool) {
+	if v, ok := reg.sessions.Load(id); ok {
+		return v.(RPCSession), true
+	}
+	return nil, false
+}
+
+// Broadcast sends msg to all live sessions, returns the number of
+// sessions
+func (reg *SessionRegistry) Broadcast(msg jsoff.Message) int {
+	cnt := 0
+	reg.sessions.Range(func(k, v any) bool {
+		v.(RPCSession).Send(msg)
+		cnt++
+		return true
+	})
+	return cnt
+}
+
+// Close closes the session of id, returns false if there is no such
+// session
+func (reg *SessionRegistry) Close(id string, reason string) bool {
+	if v, ok := reg.sessions.Load(id); ok {
+		v.(registeredSession).close(reason)
+		return true
+	}
+	return false
+}
+
+// shutdown gracefully shuts down the sessions owned by server
+func (reg *SessionRegistry) shutdown(ctx context.Context, server any) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+	reg.sessions.Range(func(k, v any) bool {
+		session := v.(registeredSession)
+		if session.owner() != server {
+			return true
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := session.shutdown(ctx); err != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+		}()
+		return true
+	})
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
@@ -3,9 +3,11 @@ package jsoffnet
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -16,21 +18,74 @@ import (
 type pendingRequest struct {
 	reqmsg        *jsoff.RequestMessage
 	resultChannel chan jsoff.Message
+	errorChannel  chan error
 	expire        time.Time
+	expiry        *timerItem
+	progress      ProgressHandler
 }
 
+// the default timeout of streaming calls if ClientOptions.Timeout is
+// not set
+const DefaultStreamingTimeout = 10 * time.Second
+
 // errors
 var TransportConnectFailed = errors.New("connect refused")
 var TransportClosed = errors.New("streaming closed")
 
-// the underline transport, currently there are websocket, h2 and net socket
-// implementations
+// Transport is the underline connection of a StreamingClient,
+// currently there are websocket, h2, tcp and vsock implementations.
+// The client calls ReadMessage and WriteMessage from one goroutine
+// each, concurrently with each other and with Close, so they should be
+// guarded against a closing connection. A new transport registers
+// its client by RegisterClientScheme.
 type Transport interface {
+	// Connect opens the connection to serverUrl with the extra
+	// header, if any. It returns TransportConnectFailed when the
+	// server is unreachable so the failure is retryable.
 	Connect(rootCtx context.Context, serverUrl *url.URL, header http.Header) error
+
+	// Close closes the connection, the blocking ReadMessage and
+	// WriteMessage return TransportClosed
 	Close()
+
+	// Connected returns whether the connection is open
 	Connected() bool
+
+	// ReadMessage blocks until a message arrives, readed is false
+	// if the data read is not a message and should be skipped. It
+	// returns TransportClosed when the connection is closed.
 	ReadMessage() (msg jsoff.Message, readed bool, err error)
+
+	// WriteMessage writes msg to the connection, it returns
+	// TransportClosed when the connection is closed
 	WriteMessage(msg jsoff.Message) error
+
+	// Type returns the transport name the requests from server
+	// carry, e.g. TransportTCP
+	Type() string
+}
+
+// the connection states of a streaming client
+type connState int
+
+const (
+	stateClosed connState = iota
+	stateConnecting
+	stateConnected
+	stateClosing
+)
+
+// streamingConn holds the state of a single connection, the client
+// creates one each time it connects
+type streamingConn struct {
+	// cancelled when the connection drops
+	ctx    context.Context
+	cancel func()
+	// send channel to write messsage sequencially
+	sendChannel chan jsoff.Message
+	// references held by the loops and the dropping, protected by
+	// the stateLock of client
+	refs int
 }
 
 type StreamingClient struct {
@@ -40,12 +95,27 @@ type StreamingClient struct {
 	// extra http header taken to transports
 	extraHeader http.Header
 
-	// lock to prevent concurrent write
-	connectLock sync.Mutex
+	// protects the connection state below and closeHandler
+	stateLock sync.Mutex
+	state     connState
+	// closed when the client leaves stateConnecting or stateClosing
+	stateChanged chan struct{}
+	// the current connection, nil if the client is closed
+	conn *streamingConn
+	// channel to wait until the client is closed
+	closeChannel chan error
 
 	// jsonrpc request message pending for result
 	pendingRequests sync.Map
 
+	// expires the pending requests
+	expiries timerQueue
+
+	clientOptions ClientOptions
+
+	// requests from server being handled by actor
+	inflight inflightRequests
+
 	// on messsage handler
 	messageHandler MessageHandler
 
@@ -55,15 +125,31 @@ type StreamingClient struct {
 	// on close handler
 	closeHandler CloseHandler
 
-	// func accompanied by context, this func is called when
-	// client want to deliberatly close the connection
-	cancelFunc func()
+	// on reconnect handler
+	reconnectHandler ReconnectHandler
 
-	// send channel to write messsage sequencially
-	sendChannel chan jsoff.Message
+	// closeRequested is set when the client is closed deliberately
+	// so that it doesn't reconnect
+	closeRequested atomic.Bool
+	reconnecting   atomic.Bool
+	// closed by Close to wake up the reconnecting backoff, protected
+	// by stateLock
+	closeSignal chan struct{}
 
-	// channel to wait until connection closed
-	closeChannel chan error
+	// actor to handle the requests and notifies sent from server
+	actor *Actor
+
+	// the session id and metadata presented to actor handlers
+	sessionId string
+	meta      sessionMeta
+
+	// subscription id => channel of subscription notifies
+	subLock       sync.Mutex
+	subscriptions map[string]*clientSubscription
+	// number of ongoing Subscribe calls, the notifies arriving
+	// before the subscription id returns are kept in earlyNotifies
+	subscribing   int
+	earlyNotifies map[string][]jsoff.Message
 
 	// the underline transport adaptor in charge of read/write
 	// bytes
@@ -99,46 +185,78 @@ func (client *StreamingClient) ServerURL() *url.URL {
 	return client.serverUrl
 }
 
-func (client *StreamingClient) InitStreaming(serverUrl *url.URL, transport Transport) {
+func (client *StreamingClient) InitStreaming(serverUrl *url.URL, transport Transport, optlist ...ClientOptions) {
 	client.serverUrl = serverUrl
+	if len(optlist) > 0 {
+		client.clientOptions = optlist[0]
+	}
 	client.transport = transport
-	client.sendChannel = nil
-	client.closeChannel = nil
+	client.sessionId = jsoff.NewUuid()
+	client.meta = newSessionMeta(serverUrl.Host, nil)
 }
 
+// CloseChannel returns the channel receiving the error the client
+// closes with, nil if the client is not connected
 func (client *StreamingClient) CloseChannel() chan error {
+	client.stateLock.Lock()
+	defer client.stateLock.Unlock()
 	return client.closeChannel
 }
 
 // wait connection close and return error
 func (client *StreamingClient) Wait() error {
-	if client.closeChannel != nil {
-		err := <-client.closeChannel
-		return err
-	} else {
+	closeChannel := client.CloseChannel()
+	if closeChannel == nil {
 		// client not connected, just return
 		return nil
 	}
+	return <-closeChannel
 }
 
+// Close closes the client deliberately, the client doesn't reconnect
 func (client *StreamingClient) Close() {
-	if client.Connected() {
-		client.Reset(nil)
+	client.closeRequested.Store(true)
+	client.stateLock.Lock()
+	signal := client.closeSignalChannel()
+	select {
+	case <-signal:
+	default:
+		close(signal)
 	}
+	client.stateLock.Unlock()
+	client.Reset(nil)
 }
 
+// closeSignalChannel returns the channel closed by Close, it must be
+// called with stateLock held
+func (client *StreamingClient) closeSignalChannel() chan struct{} {
+	if client.closeSignal == nil {
+		client.closeSignal = make(chan struct{})
+	}
+	return client.closeSignal
+}
+
+// Reset closes the current connection and the waiters of Wait get
+// err
 func (client *StreamingClient) Reset(err error) {
-	if client.cancelFunc != nil {
-		client.cancelFunc()
-		client.cancelFunc = nil
+	client.stateLock.Lock()
+	conn := client.conn
+	connected := client.state == stateConnected
+	client.stateLock.Unlock()
+	if connected {
+		client.drop(conn, err, false)
+		return
 	}
 
-	if client.closeChannel != nil {
-		client.closeChannel <- err
-		client.closeChannel = nil
+	// the client may be waiting to reconnect
+	client.stateLock.Lock()
+	closeChannel := client.closeChannel
+	client.closeChannel = nil
+	client.stateLock.Unlock()
+	if closeChannel != nil {
+		closeChannel <- err
+		client.closeSubscriptions()
 	}
-	client.transport.Close()
-	client.sendChannel = nil
 }
 
 func (client *StreamingClient) OnMessage(handler MessageHandler) error {
@@ -149,6 +267,14 @@ func (client *StreamingClient) OnMessage(handler MessageHandler) error {
 	return nil
 }
 
+// SetActor sets the actor to handle the requests and notifies sent
+// from server, the results are written back to server. Messages whose
+// methods the actor doesn't have are passed to the message handler if
+// there is one.
+func (client *StreamingClient) SetActor(actor *Actor) {
+	client.actor = actor
+}
+
 func (client *StreamingClient) OnConnected(handler ConnectedHandler) error {
 	if client.connectedHandler != nil {
 		return errors.New("connected handler already exist!")
@@ -158,6 +284,8 @@ func (client *StreamingClient) OnConnected(handler ConnectedHandler) error {
 }
 
 func (client *StreamingClient) OnClose(handler CloseHandler) error {
+	client.stateLock.Lock()
+	defer client.stateLock.Unlock()
 	if client.closeHandler != nil {
 		return errors.New("close handler already exist!")
 	}
@@ -165,92 +293,223 @@ func (client *StreamingClient) OnClose(handler CloseHandler) error {
 	return nil
 }
 
+// OnReconnect sets the handler called after the client reconnects,
+// see ClientOptions.Reconnect
+func (client *StreamingClient) OnReconnect(handler ReconnectHandler) error {
+	if client.reconnectHandler != nil {
+		return errors.New("reconnect handler already exist!")
+	}
+	client.reconnectHandler = handler
+	return nil
+}
+
 func (client *StreamingClient) Connect(rootCtx context.Context) error {
-	client.connectLock.Lock()
-	defer client.connectLock.Unlock()
+	if client.closeRequested.CompareAndSwap(true, false) {
+		// reopened after Close
+		client.stateLock.Lock()
+		client.closeSignal = nil
+		client.stateLock.Unlock()
+	}
+	return client.connect(rootCtx)
+}
 
-	if !client.transport.Connected() {
-		if err := client.transport.Connect(rootCtx, client.serverUrl, client.extraHeader); err != nil {
-			//client.connectErr = err
-			return err
-		}
-		if client.connectedHandler != nil {
-			client.connectedHandler()
+// connect moves the client from stateClosed to stateConnected, it
+// waits for the ongoing connecting or closing to finish first
+func (client *StreamingClient) connect(rootCtx context.Context) error {
+	for {
+		client.stateLock.Lock()
+		switch client.state {
+		case stateConnected:
+			client.stateLock.Unlock()
+			return nil
+		case stateConnecting, stateClosing:
+			wait := client.stateChanged
+			client.stateLock.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-rootCtx.Done():
+				return rootCtx.Err()
+			}
 		}
-		connCtx, cancel := context.WithCancel(rootCtx)
-		client.cancelFunc = cancel
-		client.sendChannel = make(chan jsoff.Message, 100)
+		// stateClosed
+		client.setState(stateConnecting)
+		client.stateLock.Unlock()
+		break
+	}
+
+	err := client.transport.Connect(rootCtx, client.serverUrl, client.extraHeader)
+	if err == nil && client.closeRequested.Load() {
+		// closed while connecting
+		client.transport.Close()
+		err = TransportClosed
+	}
+
+	client.stateLock.Lock()
+	if err != nil {
+		client.setState(stateClosed)
+		client.stateLock.Unlock()
+		return err
+	}
+	// the connection outlives the call connecting it, only drop,
+	// i.e. Close, Reset or a transport failure, cancels it
+	connCtx, cancel := context.WithCancel(context.Background())
+	conn := &streamingConn{
+		ctx:         connCtx,
+		cancel:      cancel,
+		sendChannel: make(chan jsoff.Message, 100),
+		// held by sendLoop and recvLoop
+		refs: 2,
+	}
+	client.conn = conn
+	if client.closeChannel == nil {
+		// the close channel is kept while reconnecting
 		client.closeChannel = make(chan error, 10)
-		go client.sendLoop(connCtx)
-		go client.recvLoop()
-	} else {
-		client.Log().Debug("client already connected")
 	}
+	client.setState(stateConnected)
+	client.stateLock.Unlock()
+
+	if client.connectedHandler != nil {
+		client.connectedHandler()
+	}
+	go client.sendLoop(conn)
+	go client.recvLoop(conn)
 	return nil
 }
 
-func (client *StreamingClient) handleError(err error) {
+// setState must be called with stateLock held
+func (client *StreamingClient) setState(state connState) {
+	client.state = state
+	if client.stateChanged != nil {
+		close(client.stateChanged)
+		client.stateChanged = nil
+	}
+	if state == stateConnecting || state == stateClosing {
+		client.stateChanged = make(chan struct{})
+	}
+}
+
+// release drops a reference of conn, the client is closed when the
+// loops and the dropping of conn are all finished
+func (client *StreamingClient) release(conn *streamingConn) {
+	client.stateLock.Lock()
+	defer client.stateLock.Unlock()
+	conn.refs--
+	if conn.refs == 0 && client.conn == conn {
+		client.conn = nil
+		client.setState(stateClosed)
+	}
+}
+
+// drop closes conn, the pending requests fail with TransportClosed.
+// The client reconnects if it is allowed and the reconnect policy is
+// enabled, otherwise it is closed with err.
+func (client *StreamingClient) drop(conn *streamingConn, err error, allowReconnect bool) {
+	client.stateLock.Lock()
+	if client.conn != conn || client.state != stateConnected {
+		// the connection is already dropped
+		client.stateLock.Unlock()
+		return
+	}
+	client.setState(stateClosing)
+	conn.refs++
+	reconnect := allowReconnect && client.clientOptions.Reconnect.Enabled && !client.closeRequested.Load()
+	var closeChannel chan error
+	closeHandler := client.closeHandler
+	if !reconnect {
+		closeChannel = client.closeChannel
+		client.closeChannel = nil
+		client.closeHandler = nil
+	}
+	client.stateLock.Unlock()
+	defer client.release(conn)
+
 	if errors.Is(err, TransportClosed) {
 		client.Log().Debug("transport closed")
 	}
-	client.Reset(err)
-	if client.closeHandler != nil {
-		client.closeHandler()
-		client.closeHandler = nil
+	client.transport.Close()
+	conn.cancel()
+	client.failPending(TransportClosed)
+	client.inflight.cancelAll()
+
+	if reconnect {
+		if closeHandler != nil {
+			closeHandler()
+		}
+		if client.reconnecting.CompareAndSwap(false, true) {
+			go client.reconnect(err)
+		}
+		return
+	}
+	if closeChannel != nil {
+		closeChannel <- err
+	}
+	client.closeSubscriptions()
+	if closeHandler != nil {
+		closeHandler()
 	}
 }
 
+// failPending fails all pending requests with err
+func (client *StreamingClient) failPending(err error) {
+	client.pendingRequests.Range(func(k, v any) bool {
+		if _, loaded := client.pendingRequests.LoadAndDelete(k); loaded {
+			if pending, ok := v.(*pendingRequest); ok {
+				client.expiries.remove(pending.expiry)
+				pending.errorChannel <- err
+			}
+		}
+		return true
+	})
+}
+
 func (client *StreamingClient) Connected() bool {
-	return client.transport.Connected()
+	client.stateLock.Lock()
+	defer client.stateLock.Unlock()
+	return client.state == stateConnected
+}
+
+// currentConn returns the connection if the client is connected
+func (client *StreamingClient) currentConn() (*streamingConn, bool) {
+	client.stateLock.Lock()
+	defer client.stateLock.Unlock()
+	return client.conn, client.state == stateConnected
 }
 
-func (client *StreamingClient) sendLoop(connCtx context.Context) {
-	//defer client.Reset(nil)
+func (client *StreamingClient) sendLoop(conn *streamingConn) {
 	defer func() {
 		client.Log().Debug("sendLoop stop")
+		client.release(conn)
 	}()
-	ctx, cancel := context.WithCancel(connCtx)
-	defer cancel()
 
 	client.Log().Debug("sendLoop start")
 	for {
-		if !client.transport.Connected() {
-			return
-		}
 		select {
-		case <-ctx.Done():
+		case <-conn.ctx.Done():
+			// conn is dropped
 			client.Log().Debug("ctx Done")
-			client.Close()
 			return
-		case msg, ok := <-client.sendChannel:
-			if !ok {
-				return
-			}
-			if !client.transport.Connected() {
-				return
-			}
+		case msg := <-conn.sendChannel:
 			err := client.transport.WriteMessage(msg)
 			if err != nil {
 				client.Log().Warnf("write msg error %s", err)
-				client.handleError(err)
+				client.drop(conn, err, true)
 				return
 			}
 		}
 	}
 }
 
-func (client *StreamingClient) recvLoop() {
+func (client *StreamingClient) recvLoop(conn *streamingConn) {
 	client.Log().Debug("recvLoop start")
 	defer func() {
 		client.Log().Debug("recvLoop stop")
+		client.release(conn)
 	}()
 	for {
-		if !client.transport.Connected() {
-			return
-		}
 		msg, readed, err := client.transport.ReadMessage()
 		if err != nil {
-			client.handleError(err)
+			client.drop(conn, err, true)
 			return
 		}
 		if !readed {
@@ -259,7 +518,18 @@ func (client *StreamingClient) recvLoop() {
 
 		// assert msg != nil
 		if !msg.IsResultOrError() {
-			if client.messageHandler != nil {
+			if client.inflight.handleCancel(msg) {
+				continue
+			}
+			if client.handleProgress(msg) {
+				continue
+			}
+			if client.deliverSubscription(msg) {
+				continue
+			}
+			if client.actor != nil && (client.messageHandler == nil || client.actor.Has(msg.MustMethod())) {
+				go client.feedActor(conn.ctx, msg)
+			} else if client.messageHandler != nil {
 				client.messageHandler(msg)
 			} else {
 				msg.Log().Debug("no message handler found")
@@ -270,6 +540,25 @@ func (client *StreamingClient) recvLoop() {
 	}
 }
 
+// feedActor dispatches a server message to actor and sends the result
+// back to server
+func (client *StreamingClient) feedActor(ctx context.Context, msg jsoff.Message) {
+	session := &clientSession{sessionMeta: client.meta, client: client, ctx: ctx}
+	reqCtx, done := client.inflight.begin(ctx, msg)
+	defer done()
+	req := NewRPCRequest(reqCtx, msg, client.transport.Type()).WithSession(session)
+	resmsg, err := client.actor.Feed(req)
+	if err != nil {
+		msg.Log().Warnf("actor.Feed error %s", err)
+		return
+	}
+	if resmsg != nil {
+		if err := client.send(ctx, resmsg); err != nil {
+			resmsg.Log().Warnf("send result error %s", err)
+		}
+	}
+}
+
 func (client *StreamingClient) handleResult(msg jsoff.Message) {
 	msgId := msg.MustId()
 	v, loaded := client.pendingRequests.LoadAndDelete(msgId)
@@ -281,6 +570,7 @@ func (client *StreamingClient) handleResult(msg jsoff.Message) {
 	}
 
 	if pending, ok := v.(*pendingRequest); ok {
+		client.expiries.remove(pending.expiry)
 		if msgId != pending.reqmsg.Id {
 			resmsg := msg.ReplaceId(pending.reqmsg.Id)
 			pending.resultChannel <- resmsg
@@ -290,13 +580,58 @@ func (client *StreamingClient) handleResult(msg jsoff.Message) {
 	}
 }
 
-func (client *StreamingClient) expire(k any, after time.Duration) {
-	time.Sleep(after)
-	v, loaded := client.pendingRequests.LoadAndDelete(k)
-	if loaded {
+// handleProgress passes the progress value to the handler of the
+// pending request, returns false if msg is not a $/progress notify
+func (client *StreamingClient) handleProgress(msg jsoff.Message) bool {
+	if !msg.IsNotify() || msg.MustMethod() != ProgressMethod {
+		return false
+	}
+	params := msg.MustParams()
+	if len(params) < 1 {
+		return true
+	}
+	m, ok := params[0].(map[string]any)
+	if !ok {
+		return true
+	}
+	token := m["token"]
+	// request ids are either int or string
+	if n, ok := token.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			token = int(i)
+		}
+	}
+	if v, ok := client.pendingRequests.Load(token); ok {
+		if pending, ok := v.(*pendingRequest); ok && pending.progress != nil {
+			pending.progress(m["value"])
+		}
+	}
+	return true
+}
+
+// callTimeout returns the timeout of a call from ClientOptions
+func (client *StreamingClient) callTimeout() time.Duration {
+	if client.clientOptions.Timeout > 0 {
+		return time.Duration(client.clientOptions.Timeout) * time.Second
+	}
+	return DefaultStreamingTimeout
+}
+
+// expire is called by the timer queue when the pending request k
+// times out, the call fails with a TimeoutError
+func (client *StreamingClient) expire(k any, pending *pendingRequest) {
+	if client.pendingRequests.CompareAndDelete(k, pending) {
+		timeout := jsoff.ErrTimeout.ToMessage(pending.reqmsg)
+		pending.errorChannel <- messageError(pending.reqmsg, timeout)
+		client.sendCancel(k)
+	}
+}
+
+// forget removes the pending request k which is no longer waited for
+func (client *StreamingClient) forget(k any) {
+	if v, loaded := client.pendingRequests.LoadAndDelete(k); loaded {
 		if pending, ok := v.(*pendingRequest); ok {
-			timeout := jsoff.ErrTimeout.ToMessage(pending.reqmsg)
-			pending.resultChannel <- timeout
+			client.expiries.remove(pending.expiry)
 		}
 	}
 }
@@ -313,12 +648,20 @@ func (client *StreamingClient) UnwrapCall(rootCtx context.Context, reqmsg *jsoff
 		}
 		return nil
 	} else {
-		return resmsg.MustError()
+		return messageError(reqmsg, resmsg)
 	}
 }
 
 func (client *StreamingClient) Call(rootCtx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
-	resmsg, err := client.request(rootCtx, reqmsg)
+	assignId(client.clientOptions.IdGenerator, reqmsg)
+	return intercept(rootCtx, client.clientOptions.Interceptors, reqmsg, callStep(client.retryCall))
+}
+
+func (client *StreamingClient) retryCall(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	if headerFromContext(ctx) != nil {
+		return nil, errors.Wrapf(ErrHeaderNotSupported, "RPC(%s)", reqmsg.Method)
+	}
+	resmsg, err := withRetry(ctx, client.clientOptions.Retry, reqmsg, client.request)
 	if err != nil {
 		return resmsg, errors.Wrapf(err, "RPC(%s)", reqmsg.Method)
 	}
@@ -331,49 +674,141 @@ func (client *StreamingClient) request(rootCtx context.Context, reqmsg *jsoff.Re
 		return nil, err
 	}
 	ch := make(chan jsoff.Message, 10)
+	errCh := make(chan error, 1)
 
-	sendmsg := reqmsg
-	if _, loaded := client.pendingRequests.Load(reqmsg.Id); loaded {
-		sendmsg = reqmsg.Clone(jsoff.NewUuid())
+	// expire at the earlier of the context deadline and the
+	// client timeout
+	expire := time.Now().Add(client.callTimeout())
+	if deadline, ok := rootCtx.Deadline(); ok && deadline.Before(expire) {
+		expire = deadline
 	}
-
 	p := &pendingRequest{
 		reqmsg:        reqmsg,
 		resultChannel: ch,
-		expire:        time.Now().Add(time.Second * 10),
+		errorChannel:  errCh,
+		expire:        expire,
+		progress:      progressFromContext(rootCtx),
+	}
+	// take a new id if the id is pending already
+	msgId := reqmsg.Id
+	for {
+		id := msgId
+		p.expiry = client.expiries.add(expire, func() {
+			client.expire(id, p)
+		})
+		if _, loaded := client.pendingRequests.LoadOrStore(id, p); !loaded {
+			break
+		}
+		client.expiries.remove(p.expiry)
+		msgId = nextId(client.clientOptions.IdGenerator)
+	}
+	sendmsg := reqmsg
+	if msgId != reqmsg.Id {
+		sendmsg = reqmsg.Clone(msgId)
+	}
+	if timeout := contextTimeout(rootCtx); timeout > 0 && reqmsg.Timeout == 0 {
+		// tell server how long to wait
+		timedmsg := *sendmsg
+		timedmsg.Timeout = timeout
+		sendmsg = &timedmsg
 	}
-	client.pendingRequests.Store(sendmsg.Id, p)
 
-	err = client.Send(rootCtx, sendmsg)
+	// the call has passed the interceptors, send it directly
+	err = classifyError(client.send(rootCtx, sendmsg))
 	if err != nil {
+		client.forget(msgId)
 		return nil, err
 	}
-	go client.expire(sendmsg.Id, time.Second*10)
-	if closeChannel := client.closeChannel; closeChannel != nil {
-		select {
-		case <-closeChannel:
-			client.closeChannel = nil
-			return nil, TransportClosed
-		case resmsg, ok := <-ch:
-			if !ok {
-				return nil, errors.New("result channel closed")
-			}
-			return resmsg, nil
-		}
-	} else {
-		resmsg, ok := <-ch
+	select {
+	case resmsg, ok := <-ch:
 		if !ok {
 			return nil, errors.New("result channel closed")
 		}
 		return resmsg, nil
+	case err := <-errCh:
+		return nil, err
+	case <-rootCtx.Done():
+		client.forget(msgId)
+		if errors.Is(rootCtx.Err(), context.DeadlineExceeded) {
+			// the deadline may be reached before the timer fires
+			select {
+			case err := <-errCh:
+				return nil, err
+			default:
+			}
+		}
+		// tell the server to stop handling the request
+		client.sendCancel(msgId)
+		return nil, rootCtx.Err()
+	}
+}
+
+// sendCancel sends a $/cancelRequest notify if the connection is
+// alive, the notify is dropped rather than blocking
+func (client *StreamingClient) sendCancel(id any) {
+	conn, ok := client.currentConn()
+	if !ok {
+		return
+	}
+	select {
+	case conn.sendChannel <- newCancelMessage(id):
+	default:
 	}
 }
 
+// Send connects the server if not connected and sends msg, it fails
+// with TransportClosed if the connection drops meanwhile
 func (client *StreamingClient) Send(rootCtx context.Context, msg jsoff.Message) error {
+	_, err := intercept(rootCtx, client.clientOptions.Interceptors, msg, sendStep(func(ctx context.Context, msg jsoff.Message) error {
+		return classifyError(client.send(ctx, msg))
+	}))
+	return err
+}
+
+func (client *StreamingClient) send(rootCtx context.Context, msg jsoff.Message) error {
+	if headerFromContext(rootCtx) != nil {
+		return ErrHeaderNotSupported
+	}
 	err := client.Connect(rootCtx)
 	if err != nil {
 		return err
 	}
-	client.sendChannel <- msg
-	return nil
+	conn, ok := client.currentConn()
+	if !ok {
+		return TransportClosed
+	}
+	select {
+	case conn.sendChannel <- msg:
+		return nil
+	case <-conn.ctx.Done():
+		return TransportClosed
+	case <-rootCtx.Done():
+		return rootCtx.Err()
+	}
+}
+
+// clientSession presents the streaming client as an RPCSession to
+// the handlers of the client actor
+type clientSession struct {
+	sessionMeta
+	client *StreamingClient
+	ctx    context.Context
+}
+
+func (session clientSession) Context() context.Context {
+	return session.ctx
+}
+
+func (session clientSession) Send(msg jsoff.Message) {
+	if err := session.client.Send(session.ctx, msg); err != nil {
+		msg.Log().Warnf("client session send error %s", err)
+	}
+}
+
+func (session clientSession) SessionID() string {
+	return session.client.sessionId
+}
+
+func (session clientSession) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	return session.client.Call(ctx, reqmsg)
 }
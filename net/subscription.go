@@ -0,0 +1,321 @@
+package jsoffnet
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/superisaac/jsoff"
+)
+
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// SubscribeCallback is called when a client subscribes, ctx is done
+// when the client unsubscribes or the session closes. The callback
+// should return quickly and stream notifications in goroutines.
+type SubscribeCallback func(ctx context.Context, params []any, sink *SubscriptionSink) error
+
+// SubscriptionSink streams notifications of a subscription to the
+// client session
+type SubscriptionSink struct {
+	id      string
+	method  string
+	session RPCSession
+	ctx     context.Context
+	cancel  func()
+}
+
+// subscription notifications are sent in the form of
+// {"method": "<prefix>_subscription", "params": {"subscription": id, "result": result}}
+func subscriptionMethod(method string) string {
+	return strings.TrimSuffix(method, "_subscribe") + "_subscription"
+}
+
+func unsubscribeMethod(method string) string {
+	return strings.TrimSuffix(method, "_subscribe") + "_unsubscribe"
+}
+
+func (sink SubscriptionSink) ID() string {
+	return sink.id
+}
+
+func (sink SubscriptionSink) Session() RPCSession {
+	return sink.session
+}
+
+// Context is done when the subscription is over
+func (sink SubscriptionSink) Context() context.Context {
+	return sink.ctx
+}
+
+// Notify sends a result to the subscriber
+func (sink *SubscriptionSink) Notify(result any) error {
+	if sink.ctx.Err() != nil {
+		return ErrSubscriptionClosed
+	}
+	ntfmsg := jsoff.NewNotifyMessage(sink.method, map[string]any{
+		"subscription": sink.id,
+		"result":       result,
+	})
+	sink.session.Send(ntfmsg)
+	return nil
+}
+
+// subscription sinks grouped by session id
+type subscriptionTable struct {
+	lock     sync.Mutex
+	sessions map[string]map[string]*SubscriptionSink
+}
+
+func newSubscriptionTable() *subscriptionTable {
+	return &subscriptionTable{
+		sessions: make(map[string]map[string]*SubscriptionSink),
+	}
+}
+
+func (t *subscriptionTable) add(sink *SubscriptionSink) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sessionId := sink.session.SessionID()
+	sinks, ok := t.sessions[sessionId]
+	if !ok {
+		sinks = make(map[string]*SubscriptionSink)
+		t.sessions[sessionId] = sinks
+	}
+	sinks[sink.id] = sink
+}
+
+func (t *subscriptionTable) remove(sessionId string, subId string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sinks, ok := t.sessions[sessionId]
+	if !ok {
+		return false
+	}
+	sink, ok := sinks[subId]
+	if !ok {
+		return false
+	}
+	sink.cancel()
+	delete(sinks, subId)
+	if len(sinks) == 0 {
+		delete(t.sessions, sessionId)
+	}
+	return true
+}
+
+func (t *subscriptionTable) removeSession(sessionId string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, sink := range t.sessions[sessionId] {
+		sink.cancel()
+	}
+	delete(t.sessions, sessionId)
+}
+
+// OnSubscribe registers a subscription method, the method returns a
+// subscription id to the client and the callback streams
+// notifications through sink. A matching *_unsubscribe method is
+// registered too, e.g. foo_subscribe and foo_unsubscribe, the
+// subscriptions are cleaned up when the session closes.
+func (a *Actor) OnSubscribe(method string, callback SubscribeCallback, setters ...HandlerSetter) error {
+	if a.Has(unsubscribeMethod(method)) {
+		return errors.New("handler already exist!")
+	}
+	subcb := func(req *RPCRequest, params []any) (any, error) {
+		session := req.Session()
+		if session == nil {
+			return nil, jsoff.ErrNotAllowed.WithData("subscription requires a streaming session")
+		}
+		ctx, cancel := context.WithCancel(session.Context())
+		sink := &SubscriptionSink{
+			id:      jsoff.NewUuid(),
+			method:  subscriptionMethod(method),
+			session: session,
+			ctx:     ctx,
+			cancel:  cancel,
+		}
+		a.subscriptions.add(sink)
+		if err := callback(ctx, params, sink); err != nil {
+			a.subscriptions.remove(session.SessionID(), sink.id)
+			return nil, err
+		}
+		return sink.id, nil
+	}
+	if err := a.OnRequest(method, subcb, setters...); err != nil {
+		return err
+	}
+
+	unsubcb := func(req *RPCRequest, params []any) (any, error) {
+		session := req.Session()
+		if session == nil {
+			return nil, jsoff.ErrNotAllowed.WithData("subscription requires a streaming session")
+		}
+		if len(params) < 1 {
+			return nil, jsoff.ParamsError("params[0] is missing, expect string")
+		}
+		subId, ok := params[0].(string)
+		if !ok {
+			return nil, jsoff.ParamsError("params[0] expect string")
+		}
+		return a.subscriptions.remove(session.SessionID(), subId), nil
+	}
+	return a.OnRequest(unsubscribeMethod(method), unsubcb)
+}
+
+// a subscription made by the streaming client, the id changes when
+// the subscription is replayed after reconnecting
+type clientSubscription struct {
+	id     string
+	method string
+	params any
+	ch     chan jsoff.Message
+}
+
+// Subscribe calls the subscription method and returns the channel
+// of subscription notifies, cancel unsubscribes and closes the
+// channel. The channel is closed too when the connection closes,
+// unless the client reconnects and replays the subscription.
+func (client *StreamingClient) Subscribe(ctx context.Context, method string, params any) (<-chan jsoff.Message, func(), error) {
+	sub := &clientSubscription{
+		method: method,
+		params: params,
+		ch:     make(chan jsoff.Message, 100),
+	}
+	if err := client.subscribe(ctx, sub); err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			client.subLock.Lock()
+			subId := sub.id
+			registered := client.subscriptions[subId] == sub
+			if registered {
+				delete(client.subscriptions, subId)
+				close(sub.ch)
+			}
+			client.subLock.Unlock()
+			if !registered || !client.Connected() {
+				return
+			}
+			unsubCtx, cancelUnsub := context.WithTimeout(context.Background(), DefaultSessionCallTimeout)
+			defer cancelUnsub()
+			unsubmsg := jsoff.NewRequestMessage(jsoff.NewUuid(), unsubscribeMethod(method), []any{subId})
+			if _, err := client.Call(unsubCtx, unsubmsg); err != nil {
+				client.Log().Warnf("unsubscribe %s error %s", subId, err)
+			}
+		})
+	}
+	return sub.ch, cancel, nil
+}
+
+// subscribe calls the subscribe method and registers sub under the
+// returned subscription id, a subscription being replayed is replaced
+// unless it has been cancelled meanwhile.
+func (client *StreamingClient) subscribe(ctx context.Context, sub *clientSubscription) error {
+	client.subLock.Lock()
+	client.subscribing++
+	client.subLock.Unlock()
+
+	subId, err := client.callSubscribe(ctx, sub.method, sub.params)
+
+	client.subLock.Lock()
+	defer client.subLock.Unlock()
+	client.subscribing--
+	early := client.earlyNotifies[subId]
+	delete(client.earlyNotifies, subId)
+	if client.subscribing == 0 {
+		client.earlyNotifies = nil
+	}
+	if err != nil {
+		return err
+	}
+	if sub.id != "" {
+		if client.subscriptions[sub.id] != sub {
+			return ErrSubscriptionClosed
+		}
+		delete(client.subscriptions, sub.id)
+	}
+
+	for _, ntfmsg := range early {
+		select {
+		case sub.ch <- ntfmsg:
+		default:
+			ntfmsg.Log().Warnf("subscription %s channel is full, notify dropped", subId)
+		}
+	}
+	if client.subscriptions == nil {
+		client.subscriptions = make(map[string]*clientSubscription)
+	}
+	sub.id = subId
+	client.subscriptions[subId] = sub
+	return nil
+}
+
+func (client *StreamingClient) callSubscribe(ctx context.Context, method string, params any) (string, error) {
+	reqmsg := jsoff.NewRequestMessage(jsoff.NewUuid(), method, params)
+	resmsg, err := client.Call(ctx, reqmsg)
+	if err != nil {
+		return "", err
+	}
+	if resmsg.IsError() {
+		return "", resmsg.MustError()
+	}
+	subId, ok := resmsg.MustResult().(string)
+	if !ok {
+		return "", errors.New("subscription id is not string")
+	}
+	return subId, nil
+}
+
+// deliverSubscription delivers a subscription notify to its channel,
+// returns false if msg is not a subscription notify.
+func (client *StreamingClient) deliverSubscription(msg jsoff.Message) bool {
+	if !msg.IsNotify() {
+		return false
+	}
+	params := msg.MustParams()
+	if len(params) != 1 {
+		return false
+	}
+	m, ok := params[0].(map[string]any)
+	if !ok {
+		return false
+	}
+	subId, ok := m["subscription"].(string)
+	if !ok {
+		return false
+	}
+
+	client.subLock.Lock()
+	defer client.subLock.Unlock()
+	if sub, ok := client.subscriptions[subId]; ok {
+		select {
+		case sub.ch <- msg:
+		default:
+			msg.Log().Warnf("subscription %s channel is full, notify dropped", subId)
+		}
+		return true
+	} else if client.subscribing > 0 {
+		// the result of subscribe call is not yet arrived
+		if client.earlyNotifies == nil {
+			client.earlyNotifies = make(map[string][]jsoff.Message)
+		}
+		client.earlyNotifies[subId] = append(client.earlyNotifies[subId], msg)
+		return true
+	}
+	return false
+}
+
+// closeSubscriptions closes all subscription channels
+func (client *StreamingClient) closeSubscriptions() {
+	client.subLock.Lock()
+	defer client.subLock.Unlock()
+	for subId, sub := range client.subscriptions {
+		close(sub.ch)
+		delete(client.subscriptions, subId)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 type TCPClient struct {
@@ -21,18 +22,27 @@ type TCPClient struct {
 }
 
 type tcpTransport struct {
+	// protects conn and decoder, which are replaced when connecting
+	lock    sync.Mutex
 	conn    net.Conn
 	decoder *json.Decoder
 	client  *TCPClient
 }
 
-func NewTCPClient(serverUrl *url.URL) *TCPClient {
+func init() {
+	factory := func(serverUrl *url.URL, opts ClientOptions) (Client, error) {
+		return NewTCPClient(serverUrl, opts), nil
+	}
+	RegisterClientScheme("tcp", factory)
+}
+
+func NewTCPClient(serverUrl *url.URL, optlist ...ClientOptions) *TCPClient {
 	if serverUrl.Scheme != "tcp" {
 		log.Panicf("server url %s is not tcp", serverUrl)
 	}
 	c := &TCPClient{}
 	transport := &tcpTransport{client: c}
-	c.InitStreaming(serverUrl, transport)
+	c.InitStreaming(serverUrl, transport, optlist...)
 	return c
 }
 
@@ -42,16 +52,30 @@ func (client *TCPClient) String() string {
 
 // websocket transport methods
 func (t *tcpTransport) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	if t.conn != nil {
 		t.conn.Close()
 		t.conn = nil
 	}
 }
 
-func (t tcpTransport) Connected() bool {
+func (t *tcpTransport) Type() string {
+	return TransportTCP
+}
+
+func (t *tcpTransport) Connected() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	return t.conn != nil
 }
 
+func (t *tcpTransport) current() (net.Conn, *json.Decoder) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.conn, t.decoder
+}
+
 func (t *tcpTransport) Connect(rootCtx context.Context, serverUrl *url.URL, header http.Header) error {
 	conn, err := net.Dial("tcp", serverUrl.Host)
 	if err != nil {
@@ -62,6 +86,8 @@ func (t *tcpTransport) Connect(rootCtx context.Context, serverUrl *url.URL, head
 		}
 		return errors.Wrap(err, "tcp.connect")
 	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	t.conn = conn
 	t.decoder = json.NewDecoder(bufio.NewReader(conn))
 	return nil
@@ -84,23 +110,31 @@ func (t *tcpTransport) WriteMessage(msg jsoff.Message) error {
 		return err
 	}
 
-	if _, err := t.conn.Write(marshaled); err != nil {
+	conn, _ := t.current()
+	if conn == nil {
+		return TransportClosed
+	}
+	if _, err := conn.Write(marshaled); err != nil {
 		return t.handleTCPError(err)
 	}
 	return nil
 }
 
 func (t *tcpTransport) ReadMessage() (jsoff.Message, bool, error) {
-	msg, err := jsoff.DecodeMessage(t.decoder)
+	conn, decoder := t.current()
+	if conn == nil {
+		return nil, false, TransportClosed
+	}
+	msg, err := jsoff.DecodeMessage(decoder)
 	if err != nil {
-		if errors.Is(err, io.EOF) {
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 			return nil, false, TransportClosed
 		} else if strings.Contains(err.Error(), "read/write on closed pipe") {
 			return nil, false, TransportClosed
 		}
 		t.client.Log().Warnf(
 			"bad jsonrpc message %s %s, at pos %d",
-			reflect.TypeOf(err), err, t.decoder.InputOffset())
+			reflect.TypeOf(err), err, decoder.InputOffset())
 		return nil, false, err
 	}
 	return msg, true, nil
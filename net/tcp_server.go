@@ -9,6 +9,8 @@ import (
 	"github.com/superisaac/jsoff"
 	"io"
 	"net"
+	"net/url"
+	"sync"
 )
 
 // tcp session implements RPCSession
@@ -21,21 +23,39 @@ type TCPSession struct {
 	done        chan error
 	sendChannel chan jsoff.Message
 	sessionId   string
+	caller      *sessionCaller
+	inflight    *inflightRequests
+	sessionMeta
 }
 
 type TCPServer struct {
-	Actor     *Actor
-	serverCtx context.Context
-	listener  net.Listener
+	Actor *Actor
+	// the live sessions
+	Sessions *SessionRegistry
+	listener net.Listener
+	lock     sync.Mutex
 }
 
+func init() {
+	RegisterServerScheme("tcp", func(ctx context.Context, bindUrl *url.URL, actor *Actor, tlsConfig *TLSConfig) error {
+		if tlsConfig != nil {
+			return errors.New("tls over tcp is not supported")
+		}
+		server := NewTCPServer(ctx, actor)
+		return server.Start(ctx, bindUrl.Host)
+	})
+}
+
+// NewTCPServer creates a tcp server, the sessions are not ended when
+// serverCtx is done but by Shutdown, which Start calls on its
+// rootCtx done.
 func NewTCPServer(serverCtx context.Context, actor *Actor) *TCPServer {
 	if actor == nil {
 		actor = NewActor()
 	}
 	return &TCPServer{
-		serverCtx: serverCtx,
-		Actor:     actor,
+		Actor:    actor,
+		Sessions: NewSessionRegistry(),
 	}
 }
 
@@ -44,29 +64,39 @@ func (s *TCPServer) Start(rootCtx context.Context, bind string) error {
 	if err != nil {
 		return err
 	}
+	s.lock.Lock()
 	s.listener = listener
+	s.lock.Unlock()
+
+	// shut down gracefully when rootCtx is done
+	defer shutdownOnDone(rootCtx, s)()
 
 	for {
-		if listener := s.listener; listener != nil {
-			conn, err := listener.Accept()
-			if err != nil {
-				var opErr *net.OpError
-				if errors.As(err, &opErr) {
-					// tcp server stopped
-					break
-				} else {
-					return errors.Wrap(err, "tcp.Accept")
-				}
+		conn, err := listener.Accept()
+		if err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				// tcp server stopped
+				break
+			} else {
+				return errors.Wrap(err, "tcp.Accept")
 			}
-			go s.processConnection(rootCtx, conn)
-		} else {
-			break
 		}
+		go s.processConnection(rootCtx, conn)
 	}
 	return nil
 }
 
+// Shutdown stops accepting new connections and gracefully shuts down
+// the live sessions, which are closed forcibly when ctx is done.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	s.Stop()
+	return s.Sessions.shutdown(ctx, s)
+}
+
 func (s *TCPServer) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if s.listener != nil {
 		s.listener.Close()
 		s.listener = nil
@@ -74,21 +104,35 @@ func (s *TCPServer) Stop() {
 }
 
 func (s *TCPServer) processConnection(rootCtx context.Context, conn net.Conn) {
+	// the session outlives rootCtx until Shutdown ends it
+	sessionCtx, cancel := context.WithCancel(context.WithoutCancel(rootCtx))
+	defer cancel()
 	decoder := json.NewDecoder(bufio.NewReader(conn))
 
 	session := &TCPSession{
 		server:      s,
-		rootCtx:     rootCtx,
+		rootCtx:     sessionCtx,
 		conn:        conn,
 		decoder:     decoder,
 		done:        make(chan error, 10),
 		sendChannel: make(chan jsoff.Message, 100),
 		sessionId:   jsoff.NewUuid(),
+		caller:      newSessionCaller(),
+		inflight:    &inflightRequests{},
+		sessionMeta: newSessionMeta(conn.RemoteAddr().String(), nil),
 	}
 	defer func() {
+		s.Sessions.remove(session)
 		conn.Close()
+		session.caller.close()
+		session.inflight.cancelAll()
 		s.Actor.HandleClose(session)
 	}()
+	if err := s.Actor.HandleConnect(session, nil); err != nil {
+		log.Infof("tcp session refused, %s", err)
+		return
+	}
+	s.Sessions.add(session)
 	session.wait()
 }
 
@@ -97,9 +141,6 @@ func (session *TCPSession) wait() {
 	connCtx, cancel := context.WithCancel(session.rootCtx)
 	defer cancel()
 
-	serverCtx, cancelServer := context.WithCancel(session.server.serverCtx)
-	defer cancelServer()
-
 	go session.sendLoop()
 	go session.recvLoop()
 
@@ -107,8 +148,6 @@ func (session *TCPSession) wait() {
 		select {
 		case <-connCtx.Done():
 			return
-		case <-serverCtx.Done():
-			return
 		case err, ok := <-session.done:
 			if ok && err != nil {
 				log.Warnf("websocket error %s", err)
@@ -136,8 +175,18 @@ func (session *TCPSession) recvLoop() {
 }
 
 func (session *TCPSession) msgReceived(msg jsoff.Message) {
+	if msg.IsResultOrError() && session.caller.handleResult(msg) {
+		// the result of a request sent to the client
+		return
+	}
+	if session.inflight.handleCancel(msg) {
+		return
+	}
+
+	ctx, done := session.inflight.begin(session.rootCtx, msg)
+	defer done()
 	req := NewRPCRequest(
-		session.rootCtx,
+		ctx,
 		msg,
 		TransportTCP).WithSession(session)
 
@@ -159,6 +208,26 @@ func (session *TCPSession) Send(msg jsoff.Message) {
 	session.sendChannel <- msg
 }
 
+func (session *TCPSession) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	return session.caller.call(ctx, session.Send, reqmsg)
+}
+
+// close is called by the session registry
+func (session *TCPSession) close(reason string) {
+	select {
+	case session.done <- errors.New("session closed, " + reason):
+	default:
+	}
+}
+
+func (session *TCPSession) shutdown(ctx context.Context) error {
+	return shutdownSession(ctx, session, session.sendChannel, session.inflight, session.caller)
+}
+
+func (session *TCPSession) owner() any {
+	return session.server
+}
+
 func (session TCPSession) SessionID() string {
 	return session.sessionId
 }
@@ -179,6 +248,12 @@ func (session *TCPSession) sendLoop() {
 			if !ok {
 				return
 			}
+			if msg == nil {
+				// the session is shutting down and the pending
+				// messages are written
+				session.done <- nil
+				return
+			}
 			if session.decoder == nil {
 				return
 			}
@@ -0,0 +1,120 @@
+package jsoffnet
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+type timerItem struct {
+	deadline time.Time
+	fire     func()
+	// index in heap, -1 if the item is removed or fired
+	index int
+}
+
+type timerHeap []*timerItem
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	item := x.(*timerItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// timerQueue fires callbacks at their deadlines using a single
+// timer, the zero value is ready to use
+type timerQueue struct {
+	lock  sync.Mutex
+	items timerHeap
+	timer *time.Timer
+}
+
+// add schedules fire to be called at deadline, fire is called in a
+// goroutine of timer so it should return quickly
+func (q *timerQueue) add(deadline time.Time, fire func()) *timerItem {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	item := &timerItem{deadline: deadline, fire: fire}
+	heap.Push(&q.items, item)
+	if item.index == 0 {
+		q.resetTimer()
+	}
+	return item
+}
+
+// remove cancels the scheduled item
+func (q *timerQueue) remove(item *timerItem) {
+	if item == nil {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if item.index >= 0 {
+		heap.Remove(&q.items, item.index)
+	}
+	if len(q.items) == 0 && q.timer != nil {
+		q.timer.Stop()
+	}
+}
+
+// size returns the number of scheduled items
+func (q *timerQueue) size() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items)
+}
+
+// resetTimer must be called with lock held
+func (q *timerQueue) resetTimer() {
+	if len(q.items) == 0 {
+		if q.timer != nil {
+			q.timer.Stop()
+		}
+		return
+	}
+	d := time.Until(q.items[0].deadline)
+	if q.timer == nil {
+		q.timer = time.AfterFunc(d, q.run)
+	} else {
+		q.timer.Reset(d)
+	}
+}
+
+func (q *timerQueue) run() {
+	q.lock.Lock()
+	now := time.Now()
+	var fired []*timerItem
+	for len(q.items) > 0 && !q.items[0].deadline.After(now) {
+		fired = append(fired, heap.Pop(&q.items).(*timerItem))
+	}
+	q.resetTimer()
+	q.lock.Unlock()
+
+	for _, item := range fired {
+		item.fire()
+	}
+}
@@ -9,11 +9,84 @@ import (
 	"github.com/superisaac/jsoff"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 type ClientOptions struct {
 	// client request timeout
 	Timeout int `json:"timeout" yaml:"timeout"`
+
+	// reconnect policy of streaming clients
+	Reconnect ReconnectOptions `json:"reconnect" yaml:"reconnect"`
+
+	// retry policy of calls
+	Retry RetryPolicy `json:"retry" yaml:"retry"`
+
+	// the interceptors wrapping every call and send, the first one
+	// is the outermost
+	Interceptors []ClientInterceptor `json:"-" yaml:"-"`
+
+	// the generator of request ids, the requests with nil ids take
+	// ids from it, default UuidGenerator
+	IdGenerator IdGenerator `json:"-" yaml:"-"`
+}
+
+// RetryPolicy calls idempotent methods again when they fail with
+// retryable errors: connect refused, connection dropped, timeout and
+// the retryable HTTP statuses. The retries reuse the request id.
+type RetryPolicy struct {
+	// the maximum number of attempts including the first one, no
+	// retry if MaxAttempts <= 1
+	MaxAttempts int `json:"max_attempts" yaml:"max_attempts"`
+
+	// the delay before the first retry, default 100ms
+	InitialDelay time.Duration `json:"initial_delay" yaml:"initial_delay"`
+
+	// the maximum delay between attempts, default 5s
+	MaxDelay time.Duration `json:"max_delay" yaml:"max_delay"`
+
+	// the methods safe to call more than once, "*" matches all
+	// methods
+	IdempotentMethods []string `json:"idempotent_methods" yaml:"idempotent_methods"`
+
+	// the retryable HTTP statuses, default 429, 502 and 503
+	RetryableStatus []int `json:"retryable_status" yaml:"retryable_status"`
+}
+
+// ReconnectOptions controls how a streaming client reconnects after
+// the transport drops, the delay between attempts grows exponentially
+// from InitialDelay up to MaxDelay with random jitter
+type ReconnectOptions struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// the delay before the first attempt, default 100ms
+	InitialDelay time.Duration `json:"initial_delay" yaml:"initial_delay"`
+
+	// the maximum delay between attempts, default 30s
+	MaxDelay time.Duration `json:"max_delay" yaml:"max_delay"`
+
+	// the client gives up and closes after MaxAttempts failed
+	// attempts, 0 means retrying forever
+	MaxAttempts int `json:"max_attempts" yaml:"max_attempts"`
+}
+
+// the http header carrying the milliseconds the caller is willing to
+// wait, the streaming clients use the "timeout" field of request
+// messages instead
+const TimeoutHeader = "X-Request-Timeout"
+
+// contextTimeout returns the milliseconds left before the deadline of
+// ctx, 0 if ctx has no deadline
+func contextTimeout(ctx context.Context) int64 {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	ms := time.Until(deadline).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
 }
 
 // Client is an abstract interface a client type must implement
@@ -22,11 +95,15 @@ type Client interface {
 	ServerURL() *url.URL
 
 	// Call a Request message and expect a Result|Error message.
+	// A request with a nil id must be given an id before sending,
+	// e.g. by ClientOptions.IdGenerator, HedgedCall and FanOut rely
+	// on it.
 	Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error)
 
 	// Call a Request message and unwrap the result message into a
 	// given structure, when an Error message comes it is turned
-	// into a golang error object typed *jsoff.ErrorBody
+	// into a golang error object typed *RPCError, or *TimeoutError
+	// for timeout Error messages
 	UnwrapCall(ctx context.Context, reqmsg *jsoff.RequestMessage, output any) error
 
 	// Send a JSONRPC message(usually a notify) to server without
@@ -47,6 +124,31 @@ type MessageHandler func(msg jsoff.Message)
 type ConnectedHandler func()
 type CloseHandler func()
 
+// ReconnectHandler is called after a streaming client reconnects and
+// the subscriptions are replayed
+type ReconnectHandler func()
+
+// ProgressHandler receives the values of $/progress notifies sent
+// while the request is being handled
+type ProgressHandler func(value any)
+
+type progressKeyT struct{}
+
+// ContextWithProgress returns a context, calls with it receive the
+// progress of the request through handler. Only the streaming
+// clients support progress, handler is called in the receiving
+// goroutine so it should return quickly.
+func ContextWithProgress(ctx context.Context, handler ProgressHandler) context.Context {
+	return context.WithValue(ctx, progressKeyT{}, handler)
+}
+
+func progressFromContext(ctx context.Context) ProgressHandler {
+	if handler, ok := ctx.Value(progressKeyT{}).(ProgressHandler); ok {
+		return handler
+	}
+	return nil
+}
+
 type Streamable interface {
 	Client
 
@@ -54,5 +156,7 @@ type Streamable interface {
 	OnConnected(handler ConnectedHandler) error
 	OnMessage(handler MessageHandler) error
 	OnClose(handler CloseHandler) error
+	OnReconnect(handler ReconnectHandler) error
+	SetActor(actor *Actor)
 	Wait() error
 }
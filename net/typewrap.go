@@ -2,57 +2,118 @@ package jsoffnet
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"github.com/superisaac/jsoff"
 	"reflect"
 )
 
-func typeIsStruct(tp reflect.Type) bool {
-	return (tp.Kind() == reflect.Struct ||
-		(tp.Kind() == reflect.Ptr && typeIsStruct(tp.Elem())))
-}
+var (
+	contextType   = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	stringType    = reflect.TypeOf("")
+	boolType      = reflect.TypeOf(true)
+	intType       = reflect.TypeOf(int(0))
+	int64Type     = reflect.TypeOf(int64(0))
+	float64Type   = reflect.TypeOf(float64(0))
+	interfaceType = reflect.TypeOf((*any)(nil)).Elem()
+)
 
-func interfaceToValue(a any, outputType reflect.Type) (reflect.Value, error) {
-	output := reflect.Zero(outputType).Interface()
-	config := &mapstructure.DecoderConfig{
-		Metadata: nil,
-		TagName:  "json",
-		Result:   &output,
-	}
-	decoder, err := mapstructure.NewDecoder(config)
-	if err != nil {
-		return reflect.Value{}, err
-	}
-	err = decoder.Decode(a)
-	if err != nil {
+// argDecoder converts a param into the value of a func argument
+type argDecoder func(param any) (reflect.Value, error)
+
+// resultEncoder converts the func result into a value ready to be
+// marshaled
+type resultEncoder func(val reflect.Value) (any, error)
+
+// decodeValue converts a param into outputType, applying the decode
+// hooks registered in jsoff
+func decodeValue(a any, outputType reflect.Type) (reflect.Value, error) {
+	output := reflect.New(outputType)
+	if err := jsoff.DecodeInterface(a, output.Interface()); err != nil {
 		return reflect.Value{}, err
 	}
-	return reflect.ValueOf(output), nil
+	return output.Elem(), nil
 }
 
-func valueToInterface(tp reflect.Type, val reflect.Value) (any, error) {
-	var output any
-	if typeIsStruct(tp) {
-		output = make(map[string]any)
-	} else {
-		output = reflect.Zero(tp).Interface()
+// newArgDecoder returns a decoder of argType, the common builtin
+// types take a fast path without reflecting params
+func newArgDecoder(argType reflect.Type) argDecoder {
+	fallback := func(param any) (reflect.Value, error) {
+		return decodeValue(param, argType)
 	}
-	config := &mapstructure.DecoderConfig{
-		Metadata: nil,
-		TagName:  "json",
-		Result:   &output,
+	if jsoff.HasDecodeHook(argType) {
+		return fallback
 	}
-	decoder, err := mapstructure.NewDecoder(config)
-	if err != nil {
-		return nil, err
+	switch argType {
+	case interfaceType:
+		return func(param any) (reflect.Value, error) {
+			if param == nil {
+				return reflect.Zero(argType), nil
+			}
+			return reflect.ValueOf(param), nil
+		}
+	case stringType:
+		return func(param any) (reflect.Value, error) {
+			if s, ok := param.(string); ok {
+				return reflect.ValueOf(s), nil
+			}
+			return fallback(param)
+		}
+	case boolType:
+		return func(param any) (reflect.Value, error) {
+			if b, ok := param.(bool); ok {
+				return reflect.ValueOf(b), nil
+			}
+			return fallback(param)
+		}
+	case intType, int64Type:
+		return func(param any) (reflect.Value, error) {
+			switch v := param.(type) {
+			case json.Number:
+				if n, err := v.Int64(); err == nil {
+					return reflect.ValueOf(n).Convert(argType), nil
+				}
+			case int:
+				return reflect.ValueOf(v).Convert(argType), nil
+			}
+			return fallback(param)
+		}
+	case float64Type:
+		return func(param any) (reflect.Value, error) {
+			switch v := param.(type) {
+			case json.Number:
+				if f, err := v.Float64(); err == nil {
+					return reflect.ValueOf(f), nil
+				}
+			case float64:
+				return reflect.ValueOf(v), nil
+			}
+			return fallback(param)
+		}
 	}
-	err = decoder.Decode(val.Interface())
-	if err != nil {
-		return nil, err
+	return fallback
+}
+
+// newResultEncoder returns the encoder of resType, the results
+// including structs are marshaled directly unless there are encode
+// hooks registered in jsoff.
+func newResultEncoder(resType reflect.Type) resultEncoder {
+	switch resType.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return func(val reflect.Value) (any, error) {
+			// avoid returning typed nil
+			if val.IsNil() {
+				return nil, nil
+			}
+			return jsoff.EncodeValue(val.Interface())
+		}
+	default:
+		return func(val reflect.Value) (any, error) {
+			return jsoff.EncodeValue(val.Interface())
+		}
 	}
-	return output, nil
 }
 
 type FirstArgSpec interface {
@@ -76,8 +137,7 @@ func (spec ReqSpec) String() string {
 type ContextSpec struct{}
 
 func (spec ContextSpec) Check(firstArgType reflect.Type) bool {
-	ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
-	return firstArgType.Kind() == reflect.Interface && firstArgType.Implements(ctxType)
+	return firstArgType.Kind() == reflect.Interface && firstArgType.Implements(contextType)
 }
 func (spec ContextSpec) Value(req *RPCRequest) any {
 	return req.Context()
@@ -86,8 +146,28 @@ func (spec ContextSpec) String() string {
 	return "context.Context"
 }
 
-func wrapTyped(tfunc any, firstArgSpec FirstArgSpec) (RequestCallback, error) {
+// typedHandler holds the reflection metadata of a typed func, which
+// is computed once at registration time
+type typedHandler struct {
+	fn           reflect.Value
+	funcType     reflect.Type
+	firstArgSpec FirstArgSpec
+	firstArgNum  int
 
+	// decoders of the fixed args except the first arg
+	argDecoders []argDecoder
+
+	// decoder of the variadic arg elements, nil if func is not
+	// variadic
+	variadicDecoder argDecoder
+
+	// the least params required
+	minParams int
+
+	encodeResult resultEncoder
+}
+
+func newTypedHandler(tfunc any, firstArgSpec FirstArgSpec) (*typedHandler, error) {
 	funcType := reflect.TypeOf(tfunc)
 	if funcType.Kind() != reflect.Func {
 		return nil, errors.New("tfunc is not func type")
@@ -95,9 +175,8 @@ func wrapTyped(tfunc any, firstArgSpec FirstArgSpec) (RequestCallback, error) {
 
 	numIn := funcType.NumIn()
 
-	requireFirstArg := firstArgSpec != (FirstArgSpec)(nil)
 	firstArgNum := 0
-	if requireFirstArg {
+	if firstArgSpec != nil {
 		firstArgNum = 1
 		// check inputs and 1st argument
 		if numIn < firstArgNum {
@@ -115,56 +194,103 @@ func wrapTyped(tfunc any, firstArgSpec FirstArgSpec) (RequestCallback, error) {
 		return nil, errors.New("func return number must be 2")
 	}
 
-	errType := funcType.Out(1)
-	errInterface := reflect.TypeOf((*error)(nil)).Elem()
-
-	if !errType.Implements(errInterface) {
+	if !funcType.Out(1).Implements(errorType) {
 		return nil, errors.New("second output does not implement error")
 	}
 
-	handler := func(req *RPCRequest, params []any) (any, error) {
-		// check inputs
-		if numIn > len(params)+firstArgNum {
-			return nil, jsoff.ParamsError("no enough params size")
+	th := &typedHandler{
+		fn:           reflect.ValueOf(tfunc),
+		funcType:     funcType,
+		firstArgSpec: firstArgSpec,
+		firstArgNum:  firstArgNum,
+		encodeResult: newResultEncoder(funcType.Out(0)),
+	}
+
+	// the trailing pointer args are optional, they are nil when
+	// absent, a variadic func receives all the remaining params
+	numFixed := numIn
+	if funcType.IsVariadic() {
+		numFixed--
+		th.variadicDecoder = newArgDecoder(funcType.In(numFixed).Elem())
+	}
+	for i := firstArgNum; i < numFixed; i++ {
+		th.argDecoders = append(th.argDecoders, newArgDecoder(funcType.In(i)))
+	}
+	th.minParams = len(th.argDecoders)
+	for i := numFixed - 1; i >= firstArgNum; i-- {
+		if funcType.In(i).Kind() != reflect.Ptr {
+			break
 		}
+		th.minParams--
+	}
+	return th, nil
+}
+
+// argType returns the expected type of the param at index j
+func (th typedHandler) argType(j int) reflect.Type {
+	if j < len(th.argDecoders) {
+		return th.funcType.In(j + th.firstArgNum)
+	}
+	return th.funcType.In(th.funcType.NumIn() - 1).Elem()
+}
 
-		// params -> []reflect.Value
-		fnArgs := []reflect.Value{}
-		if requireFirstArg {
-			v := firstArgSpec.Value(req)
-			fnArgs = append(fnArgs, reflect.ValueOf(v))
+func (th *typedHandler) call(req *RPCRequest, params []any) (any, error) {
+	maxParams := len(th.argDecoders)
+	// check inputs
+	if len(params) < th.minParams {
+		return nil, jsoff.ParamsError(
+			fmt.Sprintf("params[%d] is missing, expect %s", len(params), th.argType(len(params))))
+	}
+	if th.variadicDecoder == nil && len(params) > maxParams {
+		return nil, jsoff.ParamsError(
+			fmt.Sprintf("params[%d] is unexpected, expect at most %d params", maxParams, maxParams))
+	}
+
+	// params -> []reflect.Value
+	fnArgs := make([]reflect.Value, 0, th.firstArgNum+len(params)+maxParams)
+	if th.firstArgSpec != nil {
+		v := th.firstArgSpec.Value(req)
+		fnArgs = append(fnArgs, reflect.ValueOf(v))
+	}
+	for j, param := range params {
+		decoder := th.variadicDecoder
+		if j < maxParams {
+			decoder = th.argDecoders[j]
 		}
-		j := 0
-		for i := firstArgNum; i < numIn; i++ {
-			argType := funcType.In(i)
-			param := params[j]
-			j++
-
-			argValue, err := interfaceToValue(param, argType)
-			if err != nil {
-				return nil, jsoff.ParamsError(
-					fmt.Sprintf("params %d %s", i+1, err))
-			}
-			fnArgs = append(fnArgs, argValue)
 
+		if param == nil && th.argType(j).Kind() == reflect.Ptr {
+			fnArgs = append(fnArgs, reflect.Zero(th.argType(j)))
+			continue
 		}
 
-		// wrap result
-		resValues := reflect.ValueOf(tfunc).Call(fnArgs)
-		resType := funcType.Out(0)
-		errRes := resValues[1].Interface()
-		if errRes != nil {
-			if err, ok := errRes.(error); ok {
-				return nil, err
-			} else {
-				return nil, errors.New(fmt.Sprintf("error return is not error %+v", errRes))
-			}
+		argValue, err := decoder(param)
+		if err != nil {
+			return nil, jsoff.ParamsError(
+				fmt.Sprintf("params[%d] expect %s, %s", j, th.argType(j), err))
 		}
+		fnArgs = append(fnArgs, argValue)
+	}
+	// absent optional args
+	for j := len(params); j < maxParams; j++ {
+		fnArgs = append(fnArgs, reflect.Zero(th.argType(j)))
+	}
 
-		res, err := valueToInterface(
-			resType, resValues[0])
-		return res, err
+	// wrap result
+	resValues := th.fn.Call(fnArgs)
+	if errRes := resValues[1].Interface(); errRes != nil {
+		if err, ok := errRes.(error); ok {
+			return nil, err
+		} else {
+			return nil, errors.New(fmt.Sprintf("error return is not error %+v", errRes))
+		}
 	}
+	return th.encodeResult(resValues[0])
+}
 
-	return handler, nil
+func wrapTyped(tfunc any, firstArgSpec FirstArgSpec) (RequestCallback, error) {
+	th, err := newTypedHandler(tfunc, firstArgSpec)
+	if err != nil {
+		return nil, err
+	}
+	return th.call, nil
 }
@@ -0,0 +1,166 @@
+package jsoffnet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"github.com/stretchr/testify/assert"
+	"github.com/superisaac/jsoff"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type benchPoint struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Label string `json:"label"`
+}
+
+func benchMove(p benchPoint, dx int, label string) (benchPoint, error) {
+	return benchPoint{X: p.X + dx, Y: p.Y, Label: label}, nil
+}
+
+var benchParams = []any{
+	map[string]any{"x": json.Number("3"), "y": json.Number("4"), "label": "a"},
+	json.Number("5"),
+	"moved",
+}
+
+// legacyWrapTyped is the OnTyped dispatch before typed handler
+// metadata was cached, kept here as the benchmark baseline
+func legacyWrapTyped(tfunc any) RequestCallback {
+	interfaceToValue := func(a any, outputType reflect.Type) (reflect.Value, error) {
+		output := reflect.Zero(outputType).Interface()
+		config := &mapstructure.DecoderConfig{
+			TagName: "json",
+			Result:  &output,
+		}
+		decoder, err := mapstructure.NewDecoder(config)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if err := decoder.Decode(a); err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(output), nil
+	}
+
+	valueToInterface := func(tp reflect.Type, val reflect.Value) (any, error) {
+		var output any
+		if tp.Kind() == reflect.Struct {
+			output = make(map[string]any)
+		} else {
+			output = reflect.Zero(tp).Interface()
+		}
+		config := &mapstructure.DecoderConfig{
+			TagName: "json",
+			Result:  &output,
+		}
+		decoder, err := mapstructure.NewDecoder(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := decoder.Decode(val.Interface()); err != nil {
+			return nil, err
+		}
+		return output, nil
+	}
+
+	return func(req *RPCRequest, params []any) (any, error) {
+		funcType := reflect.TypeOf(tfunc)
+		fnArgs := []reflect.Value{}
+		for i := 0; i < funcType.NumIn(); i++ {
+			argValue, err := interfaceToValue(params[i], funcType.In(i))
+			if err != nil {
+				return nil, jsoff.ParamsError(fmt.Sprintf("params %d %s", i+1, err))
+			}
+			fnArgs = append(fnArgs, argValue)
+		}
+		resValues := reflect.ValueOf(tfunc).Call(fnArgs)
+		if errRes := resValues[1].Interface(); errRes != nil {
+			return nil, errRes.(error)
+		}
+		return valueToInterface(funcType.Out(0), resValues[0])
+	}
+}
+
+func TestTypedHandlerResult(t *testing.T) {
+	assert := assert.New(t)
+
+	handler, err := wrapTyped(benchMove, nil)
+	assert.Nil(err)
+
+	req := NewRPCRequest(context.Background(), nil, TransportHTTP)
+	res, err := handler(req, benchParams)
+	assert.Nil(err)
+	// struct results are not converted into maps
+	assert.Equal(benchPoint{X: 8, Y: 4, Label: "moved"}, res)
+
+	data, err := json.Marshal(res)
+	assert.Nil(err)
+	assert.Equal(`{"x":8,"y":4,"label":"moved"}`, string(data))
+
+	handler1, err := wrapTyped(func() (*benchPoint, error) {
+		return nil, nil
+	}, nil)
+	assert.Nil(err)
+	res1, err := handler1(req, []any{})
+	assert.Nil(err)
+	assert.Nil(res1)
+}
+
+func TestTypedHandlerHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	handler, err := wrapTyped(func(at time.Time, d time.Duration, data []byte, n *big.Int) (string, error) {
+		return fmt.Sprintf("%d %s %s %s", at.Year(), d, data, n), nil
+	}, nil)
+	assert.Nil(err)
+
+	req := NewRPCRequest(context.Background(), nil, TransportHTTP)
+	res, err := handler(req, []any{"2023-05-01T10:00:00Z", "2s", "aGk=", json.Number("12345678901234567890")})
+	assert.Nil(err)
+	assert.Equal("2023 2s hi 12345678901234567890", res)
+
+	_, err = handler(req, []any{"yesterday", "2s", "aGk=", 1})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "params[0] expect time.Time")
+}
+
+func BenchmarkTypedDispatch(b *testing.B) {
+	handler, err := wrapTyped(benchMove, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := NewRPCRequest(context.Background(), nil, TransportHTTP)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, err := handler(req, benchParams)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := json.Marshal(res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLegacyTypedDispatch(b *testing.B) {
+	handler := legacyWrapTyped(benchMove)
+	req := NewRPCRequest(context.Background(), nil, TransportHTTP)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, err := handler(req, benchParams)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := json.Marshal(res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
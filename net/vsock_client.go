@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/mdlayher/vsock"
 	"github.com/pkg/errors"
@@ -24,18 +25,27 @@ type VsockClient struct {
 }
 
 type vsockTransport struct {
+	// protects conn and decoder, which are replaced when connecting
+	lock    sync.Mutex
 	conn    *vsock.Conn
 	decoder *json.Decoder
 	client  *VsockClient
 }
 
-func NewVsockClient(serverUrl *url.URL) *VsockClient {
+func init() {
+	factory := func(serverUrl *url.URL, opts ClientOptions) (Client, error) {
+		return NewVsockClient(serverUrl, opts), nil
+	}
+	RegisterClientScheme("vsock", factory)
+}
+
+func NewVsockClient(serverUrl *url.URL, optlist ...ClientOptions) *VsockClient {
 	if serverUrl.Scheme != "vsock" {
 		log.Panicf("server url %s is not vsock", serverUrl)
 	}
 	c := &VsockClient{}
 	transport := &vsockTransport{client: c}
-	c.InitStreaming(serverUrl, transport)
+	c.InitStreaming(serverUrl, transport, optlist...)
 	return c
 }
 
@@ -45,16 +55,30 @@ func (client *VsockClient) String() string {
 
 // websocket transport methods
 func (t *vsockTransport) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	if t.conn != nil {
 		t.conn.Close()
 		t.conn = nil
 	}
 }
 
-func (t vsockTransport) Connected() bool {
+func (t *vsockTransport) Type() string {
+	return TransportVsock
+}
+
+func (t *vsockTransport) Connected() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	return t.conn != nil
 }
 
+func (t *vsockTransport) current() (*vsock.Conn, *json.Decoder) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.conn, t.decoder
+}
+
 func (t *vsockTransport) Connect(rootCtx context.Context, serverUrl *url.URL, header http.Header) error {
 	// serverUrl is in the form of "vsock://<contextId>:<port>"
 	contextID, err := strconv.ParseUint(serverUrl.Hostname(), 10, 32)
@@ -74,6 +98,8 @@ func (t *vsockTransport) Connect(rootCtx context.Context, serverUrl *url.URL, he
 		}
 		return errors.Wrap(err, "vsock.connect")
 	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	t.conn = conn
 	t.decoder = json.NewDecoder(bufio.NewReader(conn))
 	return nil
@@ -96,23 +122,31 @@ func (t *vsockTransport) WriteMessage(msg jsoff.Message) error {
 		return err
 	}
 
-	if _, err := t.conn.Write(marshaled); err != nil {
+	conn, _ := t.current()
+	if conn == nil {
+		return TransportClosed
+	}
+	if _, err := conn.Write(marshaled); err != nil {
 		return t.handleTCPError(err)
 	}
 	return nil
 }
 
 func (t *vsockTransport) ReadMessage() (jsoff.Message, bool, error) {
-	msg, err := jsoff.DecodeMessage(t.decoder)
+	conn, decoder := t.current()
+	if conn == nil {
+		return nil, false, TransportClosed
+	}
+	msg, err := jsoff.DecodeMessage(decoder)
 	if err != nil {
-		if errors.Is(err, io.EOF) {
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 			return nil, false, TransportClosed
 		} else if strings.Contains(err.Error(), "read/write on closed pipe") {
 			return nil, false, TransportClosed
 		}
 		t.client.Log().Warnf(
 			"bad jsonrpc message %s %s, at pos %d",
-			reflect.TypeOf(err), err, t.decoder.InputOffset())
+			reflect.TypeOf(err), err, decoder.InputOffset())
 		return nil, false, err
 	}
 	return msg, true, nil
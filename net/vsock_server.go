@@ -10,6 +10,9 @@ import (
 	"github.com/superisaac/jsoff"
 	"io"
 	"net"
+	"net/url"
+	"strconv"
+	"sync"
 )
 
 // vsock session implements RPCSession
@@ -22,21 +25,44 @@ type VsockSession struct {
 	done        chan error
 	sendChannel chan jsoff.Message
 	sessionId   string
+	caller      *sessionCaller
+	inflight    *inflightRequests
+	sessionMeta
 }
 
 type VsockServer struct {
-	Actor     *Actor
-	serverCtx context.Context
-	listener  *vsock.Listener
+	Actor *Actor
+	// the live sessions
+	Sessions *SessionRegistry
+	listener *vsock.Listener
+	lock     sync.Mutex
 }
 
+func init() {
+	RegisterServerScheme("vsock", func(ctx context.Context, bindUrl *url.URL, actor *Actor, tlsConfig *TLSConfig) error {
+		if tlsConfig != nil {
+			return errors.New("tls over vsock is not supported")
+		}
+		// bindUrl is in the form of "vsock://:<port>"
+		port, err := strconv.ParseUint(bindUrl.Port(), 10, 32)
+		if err != nil {
+			return errors.Wrap(err, "vsock.parsePort")
+		}
+		server := NewVsockServer(ctx, actor)
+		return server.Start(ctx, uint32(port))
+	})
+}
+
+// NewVsockServer creates a vsock server, the sessions are not ended when
+// serverCtx is done but by Shutdown, which Start calls on its
+// rootCtx done.
 func NewVsockServer(serverCtx context.Context, actor *Actor) *VsockServer {
 	if actor == nil {
 		actor = NewActor()
 	}
 	return &VsockServer{
-		serverCtx: serverCtx,
-		Actor:     actor,
+		Actor:    actor,
+		Sessions: NewSessionRegistry(),
 	}
 }
 
@@ -45,29 +71,39 @@ func (s *VsockServer) Start(rootCtx context.Context, port uint32) error {
 	if err != nil {
 		return err
 	}
-
+	s.lock.Lock()
 	s.listener = listener
+	s.lock.Unlock()
+
+	// shut down gracefully when rootCtx is done
+	defer shutdownOnDone(rootCtx, s)()
+
 	for {
-		if listener := s.listener; listener != nil {
-			conn, err := listener.Accept()
-			if err != nil {
-				var opErr *net.OpError
-				if errors.As(err, &opErr) {
-					// vsock server stopped
-					break
-				} else {
-					return errors.Wrap(err, "vsock.Accept")
-				}
+		conn, err := listener.Accept()
+		if err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				// vsock server stopped
+				break
+			} else {
+				return errors.Wrap(err, "vsock.Accept")
 			}
-			go s.processConnection(rootCtx, conn)
-		} else {
-			break
 		}
+		go s.processConnection(rootCtx, conn)
 	}
 	return nil
 }
 
+// Shutdown stops accepting new connections and gracefully shuts down
+// the live sessions, which are closed forcibly when ctx is done.
+func (s *VsockServer) Shutdown(ctx context.Context) error {
+	s.Stop()
+	return s.Sessions.shutdown(ctx, s)
+}
+
 func (s *VsockServer) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if s.listener != nil {
 		s.listener.Close()
 		s.listener = nil
@@ -75,21 +111,35 @@ func (s *VsockServer) Stop() {
 }
 
 func (s *VsockServer) processConnection(rootCtx context.Context, conn net.Conn) {
+	// the session outlives rootCtx until Shutdown ends it
+	sessionCtx, cancel := context.WithCancel(context.WithoutCancel(rootCtx))
+	defer cancel()
 	decoder := json.NewDecoder(bufio.NewReader(conn))
 
 	session := &VsockSession{
 		server:      s,
-		rootCtx:     rootCtx,
+		rootCtx:     sessionCtx,
 		conn:        conn,
 		decoder:     decoder,
 		done:        make(chan error, 10),
 		sendChannel: make(chan jsoff.Message, 100),
 		sessionId:   jsoff.NewUuid(),
+		caller:      newSessionCaller(),
+		inflight:    &inflightRequests{},
+		sessionMeta: newSessionMeta(conn.RemoteAddr().String(), nil),
 	}
 	defer func() {
+		s.Sessions.remove(session)
 		conn.Close()
+		session.caller.close()
+		session.inflight.cancelAll()
 		s.Actor.HandleClose(session)
 	}()
+	if err := s.Actor.HandleConnect(session, nil); err != nil {
+		log.Infof("vsock session refused, %s", err)
+		return
+	}
+	s.Sessions.add(session)
 	session.wait()
 }
 
@@ -98,9 +148,6 @@ func (session *VsockSession) wait() {
 	connCtx, cancel := context.WithCancel(session.rootCtx)
 	defer cancel()
 
-	serverCtx, cancelServer := context.WithCancel(session.server.serverCtx)
-	defer cancelServer()
-
 	go session.sendLoop()
 	go session.recvLoop()
 
@@ -108,8 +155,6 @@ func (session *VsockSession) wait() {
 		select {
 		case <-connCtx.Done():
 			return
-		case <-serverCtx.Done():
-			return
 		case err, ok := <-session.done:
 			if ok && err != nil {
 				log.Warnf("websocket error %s", err)
@@ -138,8 +183,18 @@ func (session *VsockSession) recvLoop() {
 }
 
 func (session *VsockSession) msgReceived(msg jsoff.Message) {
+	if msg.IsResultOrError() && session.caller.handleResult(msg) {
+		// the result of a request sent to the client
+		return
+	}
+	if session.inflight.handleCancel(msg) {
+		return
+	}
+
+	ctx, done := session.inflight.begin(session.rootCtx, msg)
+	defer done()
 	req := NewRPCRequest(
-		session.rootCtx,
+		ctx,
 		msg,
 		TransportVsock).WithSession(session)
 
@@ -161,6 +216,26 @@ func (session *VsockSession) Send(msg jsoff.Message) {
 	session.sendChannel <- msg
 }
 
+func (session *VsockSession) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	return session.caller.call(ctx, session.Send, reqmsg)
+}
+
+// close is called by the session registry
+func (session *VsockSession) close(reason string) {
+	select {
+	case session.done <- errors.New("session closed, " + reason):
+	default:
+	}
+}
+
+func (session *VsockSession) shutdown(ctx context.Context) error {
+	return shutdownSession(ctx, session, session.sendChannel, session.inflight, session.caller)
+}
+
+func (session *VsockSession) owner() any {
+	return session.server
+}
+
 func (session VsockSession) Context() context.Context {
 	return session.rootCtx
 }
@@ -181,6 +256,12 @@ func (session *VsockSession) sendLoop() {
 			if !ok {
 				return
 			}
+			if msg == nil {
+				// the session is shutting down and the pending
+				// messages are written
+				session.done <- nil
+				return
+			}
 			if session.decoder == nil {
 				return
 			}
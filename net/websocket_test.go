@@ -2,12 +2,17 @@ package jsoffnet
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	//log "github.com/sirupsen/logrus"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/superisaac/jsoff"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -121,7 +126,7 @@ func TestTypedWSHandlerClient(t *testing.T) {
 	assert.True(resmsg2.IsError())
 	errbody2 := resmsg2.MustError()
 	assert.Equal(-32602, errbody2.Code)
-	assert.Equal("no enough params size", errbody2.Message)
+	assert.Equal("params[0] is missing, expect string", errbody2.Message)
 
 	// test add 2 numbers
 	params3 := []any{6, 3}
@@ -164,10 +169,10 @@ func TestWSClose(t *testing.T) {
 	go ListenAndServe(serverCtx, "127.0.0.1:28123", server)
 	time.Sleep(100 * time.Millisecond)
 
-	closeCalled := make(map[int]bool)
+	var closeCalled atomic.Bool
 	client := NewWSClient(urlParse("ws://127.0.0.1:28123"))
 	client.OnClose(func() {
-		closeCalled[0] = true
+		closeCalled.Store(true)
 	})
 	// right request
 	params := []any{"hello2001"}
@@ -182,5 +187,507 @@ func TestWSClose(t *testing.T) {
 	// cancel root
 	cancelServer()
 	time.Sleep(100 * time.Millisecond)
-	assert.True(closeCalled[0])
+	assert.True(closeCalled.Load())
+}
+
+func TestWSServerCallClient(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewWSHandler(rootCtx, nil)
+	server.Actor.OnRequest("askClient", func(req *RPCRequest, params []any) (any, error) {
+		reqmsg := jsoff.NewRequestMessage(1, "clientEcho", params)
+		resmsg, err := req.Session().Call(req.Context(), reqmsg)
+		if err != nil {
+			return nil, err
+		}
+		if resmsg.IsError() {
+			return nil, resmsg.MustError()
+		}
+		return resmsg.MustResult(), nil
+	})
+
+	server.Actor.OnRequest("askTimeout", func(req *RPCRequest, params []any) (any, error) {
+		ctx, cancel := context.WithTimeout(req.Context(), 50*time.Millisecond)
+		defer cancel()
+		reqmsg := jsoff.NewRequestMessage(2, "clientSilent", params)
+		resmsg, err := req.Session().Call(ctx, reqmsg)
+		if err != nil {
+			return nil, err
+		}
+		return resmsg.MustError().Message, nil
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28102", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28102"))
+	client.OnMessage(func(msg jsoff.Message) {
+		if msg.IsRequest() && msg.MustMethod() == "clientEcho" {
+			resmsg := jsoff.NewResultMessage(msg, msg.MustParams()[0])
+			client.Send(rootCtx, resmsg)
+		}
+	})
+
+	// the client echo request id 1 is the same as the outer
+	// request id
+	reqmsg := jsoff.NewRequestMessage(1, "askClient", []any{"hello2006"})
+	resmsg, err := client.Call(rootCtx, reqmsg)
+	assert.Nil(err)
+	assert.Equal("hello2006", resmsg.MustResult())
+
+	reqmsg1 := jsoff.NewRequestMessage(2, "askTimeout", []any{"hello2007"})
+	resmsg1, err := client.Call(rootCtx, reqmsg1)
+	assert.Nil(err)
+	assert.Equal(jsoff.ErrTimeout.Message, resmsg1.MustResult())
+}
+
+func TestWSClientActor(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewWSHandler(rootCtx, nil)
+	server.Actor.OnRequest("askClientAdd", func(req *RPCRequest, params []any) (any, error) {
+		// notify the client before calling
+		req.Session().Send(jsoff.NewNotifyMessage("clientNotify", []any{"before add"}))
+		reqmsg := jsoff.NewRequestMessage(jsoff.NewUuid(), "clientAdd", params)
+		resmsg, err := req.Session().Call(req.Context(), reqmsg)
+		if err != nil {
+			return nil, err
+		}
+		if resmsg.IsError() {
+			return nil, resmsg.MustError()
+		}
+		return resmsg.MustResult(), nil
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28103", server)
+	time.Sleep(10 * time.Millisecond)
+
+	notified := make(chan string, 1)
+	actor := NewActor()
+	actor.OnTyped("clientAdd", func(a, b int) (int, error) {
+		return a + b, nil
+	})
+	actor.OnTypedRequest("clientNotify", func(req *RPCRequest, text string) (any, error) {
+		assert.NotNil(req.Session())
+		notified <- text
+		return nil, nil
+	})
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28103"))
+	client.SetActor(actor)
+
+	reqmsg := jsoff.NewRequestMessage(1, "askClientAdd", []any{3, 5})
+	resmsg, err := client.Call(rootCtx, reqmsg)
+	assert.Nil(err)
+	assert.Equal(json.Number("8"), resmsg.MustResult())
+	assert.Equal("before add", <-notified)
+
+	// params error raised by the client actor
+	reqmsg1 := jsoff.NewRequestMessage(2, "askClientAdd", []any{"a", 5})
+	resmsg1, err := client.Call(rootCtx, reqmsg1)
+	assert.Nil(err)
+	assert.True(resmsg1.IsError())
+	assert.Equal(-32602, resmsg1.MustError().Code)
+}
+
+func TestWSSubscription(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subEnded := make(chan string, 10)
+	server := NewWSHandler(rootCtx, nil)
+	err := server.Actor.OnSubscribe("tick_subscribe", func(ctx context.Context, params []any, sink *SubscriptionSink) error {
+		if len(params) < 1 {
+			return jsoff.ParamsError("no count")
+		}
+		go func() {
+			// notify right after subscribed, before the id is returned
+			for i := 0; i < 3; i++ {
+				sink.Notify(i)
+			}
+			<-ctx.Done()
+			assert.Equal(ErrSubscriptionClosed, sink.Notify(100))
+			subEnded <- sink.ID()
+		}()
+		return nil
+	})
+	assert.Nil(err)
+	assert.True(server.Actor.Has("tick_unsubscribe"))
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28104", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28104"))
+
+	// subscribe error
+	_, _, err = client.Subscribe(rootCtx, "tick_subscribe", []any{})
+	assert.NotNil(err)
+
+	ch, cancelSub, err := client.Subscribe(rootCtx, "tick_subscribe", []any{3})
+	assert.Nil(err)
+	for i := 0; i < 3; i++ {
+		ntfmsg := <-ch
+		assert.Equal("tick_subscription", ntfmsg.MustMethod())
+		m, _ := ntfmsg.MustParams()[0].(map[string]any)
+		assert.Equal(json.Number(fmt.Sprintf("%d", i)), m["result"])
+	}
+
+	// unsubscribe ends the subscription on the server side
+	cancelSub()
+	subId := <-subEnded
+	_, ok := <-ch
+	assert.False(ok)
+
+	// unsubscribe an ended subscription
+	reqmsg := jsoff.NewRequestMessage(1, "tick_unsubscribe", []any{subId})
+	resmsg, err := client.Call(rootCtx, reqmsg)
+	assert.Nil(err)
+	assert.Equal(false, resmsg.MustResult())
+
+	// closing the connection ends the subscriptions too
+	ch1, _, err := client.Subscribe(rootCtx, "tick_subscribe", []any{3})
+	assert.Nil(err)
+	client.Close()
+	<-subEnded
+	for range ch1 {
+	}
+}
+
+func TestWSSessionRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewWSHandler(rootCtx, nil)
+	server.Actor.OnRequest("incr", func(req *RPCRequest, params []any) (any, error) {
+		values := req.Session().Values()
+		n, _ := values.Get("counter")
+		cnt, _ := n.(int)
+		values.Set("counter", cnt+1)
+		return cnt + 1, nil
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28105", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28105"))
+	notified := make(chan jsoff.Message, 1)
+	client.OnMessage(func(msg jsoff.Message) {
+		notified <- msg
+	})
+
+	for i := 1; i <= 2; i++ {
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(i, "incr", nil))
+		assert.Nil(err)
+		assert.Equal(json.Number(fmt.Sprintf("%d", i)), resmsg.MustResult())
+	}
+
+	sessions := server.Sessions.List()
+	assert.Equal(1, len(sessions))
+	session, ok := server.Sessions.Get(sessions[0].SessionID())
+	assert.True(ok)
+	assert.True(strings.HasPrefix(session.RemoteAddr(), "127.0.0.1:"))
+	assert.False(session.CreatedAt().IsZero())
+	assert.Nil(session.AuthInfo())
+	counter, _ := session.Values().Get("counter")
+	assert.Equal(2, counter)
+
+	assert.Equal(1, server.Sessions.Broadcast(jsoff.NewNotifyMessage("hello", nil)))
+	assert.Equal("hello", (<-notified).MustMethod())
+
+	assert.False(server.Sessions.Close("no-such-session", "kicked"))
+	assert.True(server.Sessions.Close(session.SessionID(), "kicked"))
+	client.Wait()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(0, len(server.Sessions.List()))
+}
+
+func TestWSOnConnect(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewWSHandler(rootCtx, nil)
+	server.Actor.OnConnect(func(session RPCSession, r *http.Request) error {
+		token := r.Header.Get("X-Token")
+		if token != "good" {
+			return errors.New("bad token")
+		}
+		session.Values().Set("token", token)
+		session.Send(jsoff.NewNotifyMessage("welcome", nil))
+		return nil
+	})
+	server.Actor.OnRequest("token", func(req *RPCRequest, params []any) (any, error) {
+		token, _ := req.Session().Values().Get("token")
+		return token, nil
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28106", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28106"))
+	h := http.Header{}
+	h.Set("X-Token", "good")
+	client.SetExtraHeader(h)
+	notified := make(chan jsoff.Message, 1)
+	client.OnMessage(func(msg jsoff.Message) {
+		notified <- msg
+	})
+	resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "token", nil))
+	assert.Nil(err)
+	assert.Equal("good", resmsg.MustResult())
+	assert.Equal("welcome", (<-notified).MustMethod())
+
+	// refused session
+	client1 := NewWSClient(urlParse("ws://127.0.0.1:28106"))
+	_, err = client1.Call(rootCtx, jsoff.NewRequestMessage(2, "token", nil))
+	assert.ErrorIs(err, TransportConnectFailed)
+	assert.Equal(1, len(server.Sessions.List()))
+}
+
+func TestWSShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewWSHandler(rootCtx, nil)
+	server.Actor.On("echo", func(params []any) (any, error) {
+		return params, nil
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28107", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28107"))
+	_, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "echo", []any{1}))
+	assert.Nil(err)
+
+	closed := make(chan bool, 1)
+	client.OnClose(func() {
+		closed <- true
+	})
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(rootCtx, time.Second)
+	defer cancelShutdown()
+	assert.Nil(server.Shutdown(shutdownCtx))
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		assert.Fail("client not closed")
+	}
+
+	// new sessions are refused
+	client1 := NewWSClient(urlParse("ws://127.0.0.1:28107"))
+	_, err = client1.Call(rootCtx, jsoff.NewRequestMessage(2, "echo", nil))
+	assert.NotNil(err)
+}
+
+func TestWSServerCtxDone(t *testing.T) {
+	assert := assert.New(t)
+
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	defer cancelServer()
+
+	server := NewWSHandler(serverCtx, nil)
+	server.Actor.On("slow", func(params []any) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return "done", nil
+	})
+	go ListenAndServe(serverCtx, "127.0.0.1:28110", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28110"))
+	defer client.Close()
+
+	// the in-flight call is answered before the session ends
+	time.AfterFunc(50*time.Millisecond, cancelServer)
+	resmsg, err := client.Call(context.Background(), jsoff.NewRequestMessage(1, "slow", nil))
+	if assert.Nil(err) {
+		assert.Equal("done", resmsg.MustResult())
+	}
+}
+
+func TestGatewayShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewGatewayHandler(rootCtx, nil, true)
+	server.Actor.On("sleep", func(params []any) (any, error) {
+		time.Sleep(300 * time.Millisecond)
+		return "awake", nil
+	})
+	go ListenAndServe(rootCtx, "127.0.0.1:28109", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28109"))
+	err := client.Connect(rootCtx)
+	assert.Nil(err)
+	go client.Call(rootCtx, jsoff.NewRequestMessage(1, "sleep", nil))
+	time.Sleep(20 * time.Millisecond)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownCtx, cancelShutdown := context.WithTimeout(rootCtx, time.Second)
+		defer cancelShutdown()
+		shutdownErr <- server.Shutdown(shutdownCtx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// h2 stops accepting while the websocket session drains
+	h2client := NewHttp2Client(urlParse("h2c://127.0.0.1:28109"))
+	err = h2client.Connect(rootCtx)
+	assert.ErrorIs(err, TransportConnectFailed)
+
+	select {
+	case err := <-shutdownErr:
+		assert.Nil(err)
+	case <-time.After(2 * time.Second):
+		assert.Fail("shutdown not finished")
+	}
+}
+
+func TestWSClientTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewWSHandler(rootCtx, nil)
+	server.Actor.On("echo", func(params []any) (any, error) {
+		return params[0], nil
+	})
+	server.Actor.On("sleep", func(params []any) (any, error) {
+		time.Sleep(1500 * time.Millisecond)
+		return "awake", nil
+	})
+
+	go ListenAndServe(rootCtx, "127.0.0.1:28108", server)
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewWSClient(urlParse("ws://127.0.0.1:28108"), ClientOptions{Timeout: 1})
+	assert.Equal(time.Second, client.callTimeout())
+
+	// the expiries are removed once the results arrive
+	for i := 0; i < 100; i++ {
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(i, "echo", []any{i}))
+		assert.Nil(err)
+		assert.True(resmsg.IsResult())
+	}
+	assert.Equal(0, client.expiries.size())
+
+	// timeout from ClientOptions
+	start := time.Now()
+	_, err := client.Call(rootCtx, jsoff.NewRequestMessage(1000, "sleep", nil))
+	var timeoutErr *TimeoutError
+	assert.True(errors.As(err, &timeoutErr))
+	assert.True(IsRetryable(err))
+	var errbody *jsoff.RPCError
+	assert.True(errors.As(err, &errbody))
+	assert.Equal(jsoff.ErrTimeout.Code, errbody.Code)
+	assert.True(time.Since(start) < 1400*time.Millisecond)
+	assert.Equal(0, client.expiries.size())
+}
+
+func TestSequentialSession(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	actor := NewActor()
+	actor.OnRequest("askClient", func(req *RPCRequest, params []any) (any, error) {
+		reqmsg := jsoff.NewRequestMessage(jsoff.NewUuid(), "clientEcho", params)
+		resmsg, err := req.Session().Call(req.Context(), reqmsg)
+		if err != nil {
+			return nil, err
+		}
+		return resmsg.MustResult(), nil
+	})
+	cancelled := make(chan error, 2)
+	actor.OnContext("slow", func(ctx context.Context, params []any) (any, error) {
+		select {
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return "done", nil
+		}
+	})
+
+	wsServer := NewWSHandler(rootCtx, actor)
+	wsServer.SpawnGoroutine = false
+	go ListenAndServe(rootCtx, "127.0.0.1:28111", wsServer)
+
+	h2Server := NewHttp2Handler(rootCtx, actor)
+	h2Server.SpawnGoroutine = false
+	go ListenAndServe(rootCtx, "127.0.0.1:28803", h2Server.Http2CHandler(), nil)
+	time.Sleep(10 * time.Millisecond)
+
+	for _, serverUrl := range []string{"ws://127.0.0.1:28111", "h2c://127.0.0.1:28803"} {
+		c, err := NewClient(serverUrl)
+		assert.Nil(err)
+		client := c.(Streamable)
+		client.OnMessage(func(msg jsoff.Message) {
+			if msg.IsRequest() && msg.MustMethod() == "clientEcho" {
+				client.Send(rootCtx, jsoff.NewResultMessage(msg, msg.MustParams()[0]))
+			}
+		})
+
+		// the handler reads the result of its call to the client
+		start := time.Now()
+		resmsg, err := client.Call(rootCtx, jsoff.NewRequestMessage(1, "askClient", []any{"hello"}))
+		if assert.Nil(err) {
+			assert.Equal("hello", resmsg.MustResult())
+		}
+		assert.True(time.Since(start) < time.Second)
+
+		// and can be cancelled by $/cancelRequest
+		callCtx, cancelCall := context.WithCancel(rootCtx)
+		time.AfterFunc(50*time.Millisecond, cancelCall)
+		_, err = client.Call(callCtx, jsoff.NewRequestMessage(2, "slow", nil))
+		assert.ErrorIs(err, context.Canceled)
+		select {
+		case err := <-cancelled:
+			assert.Equal(context.Canceled, err)
+		case <-time.After(time.Second):
+			assert.Fail("server handler not cancelled")
+		}
+	}
+}
+
+func TestWSDialer(t *testing.T) {
+	assert := assert.New(t)
+
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewWSHandler(rootCtx, nil)
+	go ListenAndServe(rootCtx, "127.0.0.1:28112", server)
+	time.Sleep(10 * time.Millisecond)
+
+	// the tls config of a client stays out of the shared dialer
+	client := NewWSClient(urlParse("ws://127.0.0.1:28112"))
+	client.SetClientTLSConfig(&tls.Config{ServerName: "example.com"})
+	assert.Nil(client.Connect(rootCtx))
+	client.Close()
+	assert.Nil(websocket.DefaultDialer.TLSClientConfig)
+
+	// dialing stops with ctx
+	dialCtx, cancelDial := context.WithCancel(rootCtx)
+	cancelDial()
+	client1 := NewWSClient(urlParse("ws://127.0.0.1:28112"))
+	assert.ErrorIs(client1.Connect(dialCtx), context.Canceled)
 }
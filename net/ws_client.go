@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"sync"
 )
 
 type WSClient struct {
@@ -19,18 +20,28 @@ type WSClient struct {
 }
 
 type wsTransport struct {
-	ws *websocket.Conn
+	// protects ws, which is replaced when connecting
+	lock sync.Mutex
+	ws   *websocket.Conn
 
 	client *WSClient
 }
 
-func NewWSClient(serverUrl *url.URL) *WSClient {
+func init() {
+	factory := func(serverUrl *url.URL, opts ClientOptions) (Client, error) {
+		return NewWSClient(serverUrl, opts), nil
+	}
+	RegisterClientScheme("ws", factory)
+	RegisterClientScheme("wss", factory)
+}
+
+func NewWSClient(serverUrl *url.URL, optlist ...ClientOptions) *WSClient {
 	if serverUrl.Scheme != "ws" && serverUrl.Scheme != "wss" {
 		log.Panicf("server url %s is not websocket", serverUrl)
 	}
 	c := &WSClient{}
 	transport := &wsTransport{client: c}
-	c.InitStreaming(serverUrl, transport)
+	c.InitStreaming(serverUrl, transport, optlist...)
 	return c
 }
 
@@ -40,28 +51,52 @@ func (client *WSClient) String() string {
 
 // websocket transport methods
 func (t *wsTransport) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	if t.ws != nil {
 		t.ws.Close()
 		t.ws = nil
 	}
 }
 
-func (t wsTransport) Connected() bool {
+func (t *wsTransport) Type() string {
+	return TransportWebsocket
+}
+
+func (t *wsTransport) Connected() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	return t.ws != nil
 }
 
+func (t *wsTransport) current() *websocket.Conn {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.ws
+}
+
 func (t *wsTransport) Connect(rootCtx context.Context, serverUrl *url.URL, header http.Header) error {
-	dailer := websocket.DefaultDialer
-	dailer.TLSClientConfig = t.client.ClientTLSConfig()
-	ws, _, err := dailer.Dial(serverUrl.String(), header)
+	// copy the default dialer, which is shared by all clients
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = t.client.ClientTLSConfig()
+	ws, resp, err := dialer.DialContext(rootCtx, serverUrl.String(), header)
 	if err != nil {
+		if ctxErr := rootCtx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		var opErr *net.OpError
 		if errors.As(err, &opErr) {
 			t.client.Log().Infof("websocket operror %s", opErr)
 			return TransportConnectFailed
 		}
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			// refused by server
+			return errors.Wrapf(TransportConnectFailed, "ws connect status %d", resp.StatusCode)
+		}
 		return errors.Wrap(err, "wstransport.connect")
 	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	t.ws = ws
 	return nil
 }
@@ -69,7 +104,7 @@ func (t *wsTransport) Connect(rootCtx context.Context, serverUrl *url.URL, heade
 func (t *wsTransport) handleWebsocketError(err error) error {
 	logger := t.client.Log()
 	var closeErr *websocket.CloseError
-	if errors.Is(err, io.EOF) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 		logger.Infof("websocket conn failed")
 		return TransportClosed
 	} else if errors.As(err, &closeErr) {
@@ -87,14 +122,22 @@ func (t *wsTransport) WriteMessage(msg jsoff.Message) error {
 		return err
 	}
 
-	if err := t.ws.WriteMessage(websocket.TextMessage, marshaled); err != nil {
+	ws := t.current()
+	if ws == nil {
+		return TransportClosed
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, marshaled); err != nil {
 		return t.handleWebsocketError(err)
 	}
 	return nil
 }
 
 func (t *wsTransport) ReadMessage() (jsoff.Message, bool, error) {
-	messageType, msgBytes, err := t.ws.ReadMessage()
+	ws := t.current()
+	if ws == nil {
+		return nil, false, TransportClosed
+	}
+	messageType, msgBytes, err := ws.ReadMessage()
 	if err != nil {
 		return nil, false, t.handleWebsocketError(err)
 	}
@@ -8,6 +8,8 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/superisaac/jsoff"
 	"net/http"
+	"sync/atomic"
+	"time"
 )
 
 var upgrader = websocket.Upgrader{
@@ -16,8 +18,11 @@ var upgrader = websocket.Upgrader{
 }
 
 type WSHandler struct {
-	Actor     *Actor
-	serverCtx context.Context
+	Actor *Actor
+	// the live sessions
+	Sessions *SessionRegistry
+
+	shuttingDown atomic.Bool
 	// options
 	SpawnGoroutine bool
 }
@@ -29,44 +34,78 @@ type WSSession struct {
 	rootCtx     context.Context
 	done        chan error
 	sendChannel chan jsoff.Message
+	queue       chan func()
 	sessionId   string
+	caller      *sessionCaller
+	inflight    *inflightRequests
+	sessionMeta
 }
 
+// NewWSHandler creates a websocket handler, the sessions are not
+// ended when serverCtx is done but by Shutdown, which ListenAndServe
+// calls on its ctx done.
 func NewWSHandler(serverCtx context.Context, actor *Actor) *WSHandler {
 	if actor == nil {
 		actor = NewActor()
 	}
 	return &WSHandler{
-		serverCtx:      serverCtx,
 		Actor:          actor,
+		Sessions:       NewSessionRegistry(),
 		SpawnGoroutine: true,
 	}
 }
 
+// Shutdown stops accepting new sessions and gracefully shuts down the
+// live sessions, which are closed forcibly when ctx is done.
+func (h *WSHandler) Shutdown(ctx context.Context) error {
+	h.shuttingDown.Store(true)
+	return h.Sessions.shutdown(ctx, h)
+}
+
 func (h *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Warnf("ws upgrade failed %s", err)
-		w.WriteHeader(400)
-		w.Write([]byte("ws upgrade failed"))
+	if h.shuttingDown.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("server is shutting down"))
 		return
 	}
-	defer ws.Close()
-
 	session := &WSSession{
 		server:      h,
 		rootCtx:     r.Context(),
 		httpRequest: r,
-		ws:          ws,
 		done:        make(chan error, 10),
 		sendChannel: make(chan jsoff.Message, 100),
+		queue:       make(chan func(), 100),
 		sessionId:   jsoff.NewUuid(),
+		caller:      newSessionCaller(),
+		inflight:    &inflightRequests{},
+		sessionMeta: newHTTPSessionMeta(r),
 	}
 	defer func() {
+		h.Sessions.remove(session)
+		session.caller.close()
+		session.inflight.cancelAll()
 		h.Actor.HandleClose(session)
 	}()
+	// refuse the session before upgrading, the messages sent by the
+	// connect hook wait in sendChannel
+	if err := h.Actor.HandleConnect(session, r); err != nil {
+		log.Infof("websocket session refused, %s", err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warnf("ws upgrade failed %s", err)
+		w.WriteHeader(400)
+		w.Write([]byte("ws upgrade failed"))
+		return
+	}
+	defer ws.Close()
+	session.ws = ws
+
+	h.Sessions.add(session)
 	session.wait()
-	session.server = nil
 }
 
 // websocket session
@@ -74,18 +113,16 @@ func (session *WSSession) wait() {
 	connCtx, cancel := context.WithCancel(session.rootCtx)
 	defer cancel()
 
-	serverCtx, cancelServer := context.WithCancel(session.server.serverCtx)
-	defer cancelServer()
-
 	go session.sendLoop()
 	go session.recvLoop()
+	if !session.server.SpawnGoroutine {
+		go runQueue(connCtx, session.queue)
+	}
 
 	for {
 		select {
 		case <-connCtx.Done():
 			return
-		case <-serverCtx.Done():
-			return
 		case err, ok := <-session.done:
 			if ok && err != nil {
 				log.Warnf("websocket error %s", err)
@@ -107,24 +144,39 @@ func (session *WSSession) recvLoop() {
 			continue
 		}
 
+		msg, err := jsoff.ParseBytes(msgBytes)
+		if err != nil {
+			log.Warnf("bad jsonrpc message %s", msgBytes)
+			session.done <- errors.New("bad jsonrpc message")
+			return
+		}
+
+		if msg.IsResultOrError() && session.caller.handleResult(msg) {
+			// the result of a request sent to the client
+			continue
+		}
+		if session.inflight.handleCancel(msg) {
+			continue
+		}
+
+		ctx, done := session.inflight.begin(session.rootCtx, msg)
 		if session.server.SpawnGoroutine {
-			go session.msgBytesReceived(msgBytes)
+			go session.msgReceived(ctx, msg, done)
 		} else {
-			session.msgBytesReceived(msgBytes)
+			select {
+			case session.queue <- func() { session.msgReceived(ctx, msg, done) }:
+			case <-session.rootCtx.Done():
+				done()
+				return
+			}
 		}
 	}
 }
 
-func (session *WSSession) msgBytesReceived(msgBytes []byte) {
-	msg, err := jsoff.ParseBytes(msgBytes)
-	if err != nil {
-		log.Warnf("bad jsonrpc message %s", msgBytes)
-		session.done <- errors.New("bad jsonrpc message")
-		return
-	}
-
+func (session *WSSession) msgReceived(ctx context.Context, msg jsoff.Message, done func()) {
+	defer done()
 	req := NewRPCRequest(
-		session.rootCtx,
+		ctx,
 		msg,
 		TransportWebsocket).WithHTTPRequest(session.httpRequest).WithSession(session)
 
@@ -146,6 +198,35 @@ func (session *WSSession) Send(msg jsoff.Message) {
 	session.sendChannel <- msg
 }
 
+func (session *WSSession) Call(ctx context.Context, reqmsg *jsoff.RequestMessage) (jsoff.Message, error) {
+	return session.caller.call(ctx, session.Send, reqmsg)
+}
+
+func (session *WSSession) writeClose(code int, reason string) {
+	deadline := time.Now().Add(time.Second)
+	closeData := websocket.FormatCloseMessage(code, reason)
+	if err := session.ws.WriteControl(websocket.CloseMessage, closeData, deadline); err != nil {
+		log.Debugf("write close message error %s", err)
+	}
+}
+
+// close is called by the session registry
+func (session *WSSession) close(reason string) {
+	session.writeClose(websocket.CloseNormalClosure, reason)
+	select {
+	case session.done <- errors.New("session closed, " + reason):
+	default:
+	}
+}
+
+func (session *WSSession) shutdown(ctx context.Context) error {
+	return shutdownSession(ctx, session, session.sendChannel, session.inflight, session.caller)
+}
+
+func (session *WSSession) owner() any {
+	return session.server
+}
+
 func (session WSSession) Context() context.Context {
 	return session.rootCtx
 }
@@ -166,6 +247,13 @@ func (session *WSSession) sendLoop() {
 			if !ok {
 				return
 			}
+			if msg == nil {
+				// the session is shutting down and the pending
+				// messages are written
+				session.writeClose(websocket.CloseGoingAway, "server shutdown")
+				session.done <- nil
+				return
+			}
 			if session.ws == nil {
 				return
 			}
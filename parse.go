@@ -17,6 +17,7 @@ type msgUnion struct {
 	Params  *json.RawMessage `json:"params,omitempty"`
 	Method  string           `json:"method,omitempty"`
 	TraceId string           `json:"traceid,omitempty"`
+	Timeout int64            `json:"timeout,omitempty"`
 }
 
 type decodeErrorT struct {
@@ -121,6 +122,7 @@ func DecodeMessage(decoder *json.Decoder, options ...MessageOptions) (Message, e
 			reqmsg := NewRequestMessage(un.IdSt.Value, un.Method, params)
 			reqmsg.paramsAreList = islist
 			reqmsg.SetTraceId(un.TraceId)
+			reqmsg.Timeout = un.Timeout
 			return reqmsg, nil
 		} else {
 			ntfmsg := NewNotifyMessage(un.Method, params)
@@ -175,6 +175,10 @@ func (builder *SchemaBuilder) buildMixin(schema Schema, node map[string]any, pat
 			return NewBuildError("decsription must be string", newPaths)
 		}
 	}
+
+	if v, ok := node["default"]; ok {
+		schema.SetDefault(v)
+	}
 	return nil
 }
 
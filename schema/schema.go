@@ -22,6 +22,16 @@ func (m SchemaMixin) GetDescription() string {
 	return m.description
 }
 
+func (m *SchemaMixin) SetDefault(v any) {
+	m.defaultValue = v
+	m.hasDefault = true
+}
+
+// GetDefault returns the default value and whether it is set
+func (m SchemaMixin) GetDefault() (any, bool) {
+	return m.defaultValue, m.hasDefault
+}
+
 func (m SchemaMixin) rebuildType(nType string) map[string]any {
 	tp := map[string]any{
 		"type": nType,
@@ -32,6 +42,9 @@ func (m SchemaMixin) rebuildType(nType string) map[string]any {
 	if m.description != "" {
 		tp["description"] = m.description
 	}
+	if m.hasDefault {
+		tp["default"] = m.defaultValue
+	}
 	return tp
 }
 
@@ -588,11 +601,15 @@ func (s *MethodSchema) ScanParams(validator *SchemaValidator, params []any) *Err
 	validator.pushPath(".params")
 	defer validator.popPath(".params")
 
-	if len(params) < len(s.Params) {
+	if len(params) < s.MinParams() {
 		return validator.NewErrorPos("length of params mismatch")
 	}
 
 	for i, paramSchema := range s.Params {
+		if i >= len(params) {
+			// the rest params have default values
+			break
+		}
 		errPos := validator.Scan(paramSchema, fmt.Sprintf("[%d]", i), params[i])
 		if errPos != nil {
 			return errPos
@@ -612,6 +629,33 @@ func (s *MethodSchema) ScanParams(validator *SchemaValidator, params []any) *Err
 	return nil
 }
 
+// MinParams returns the least number of params required, trailing
+// params with default values can be omitted
+func (s MethodSchema) MinParams() int {
+	n := len(s.Params)
+	for n > 0 {
+		if _, ok := s.Params[n-1].GetDefault(); !ok {
+			break
+		}
+		n--
+	}
+	return n
+}
+
+// FillDefaults appends the default values of omitted trailing params
+func (s MethodSchema) FillDefaults(params []any) []any {
+	if len(params) >= len(s.Params) || len(params) < s.MinParams() {
+		return params
+	}
+	filled := make([]any, len(params), len(s.Params))
+	copy(filled, params)
+	for _, paramSchema := range s.Params[len(params):] {
+		v, _ := paramSchema.GetDefault()
+		filled = append(filled, v)
+	}
+	return filled
+}
+
 func (s *MethodSchema) ScanResult(validator *SchemaValidator, result any) *ErrorPos {
 	if s.Returns != nil {
 		return validator.Scan(s.Returns, ".result", result)
@@ -1,7 +1,7 @@
 package jsoffschema
 
 import (
-	//json "encoding/json"
+	json "encoding/json"
 	//"fmt"
 	"testing"
 	//"reflect"
@@ -632,3 +632,41 @@ properties:
 	assert.NotNil(err)
 	assert.Contains(err.Error(), ".properties.5")
 }
+
+func TestMethodDefaultParams(t *testing.T) {
+	assert := assert.New(t)
+	s1 := []byte(`{
+"params": [
+  "string",
+  {"type": "integer", "default": 5},
+  {"type": "bool", "default": true}
+]}`)
+	builder := NewSchemaBuilder()
+	s, err := builder.BuildBytes(s1)
+	assert.Nil(err)
+	methodSchema, ok := s.(*MethodSchema)
+	assert.True(ok)
+	assert.Equal(1, methodSchema.MinParams())
+
+	dv, ok := methodSchema.Params[1].GetDefault()
+	assert.True(ok)
+	assert.Equal(json.Number("5"), dv)
+	_, ok = methodSchema.Params[0].GetDefault()
+	assert.False(ok)
+
+	assert.Equal([]any{"a", json.Number("5"), true}, methodSchema.FillDefaults([]any{"a"}))
+	assert.Equal([]any{"a", 6, true}, methodSchema.FillDefaults([]any{"a", 6}))
+	assert.Equal([]any{}, methodSchema.FillDefaults([]any{}))
+
+	validator := NewSchemaValidator()
+	errPos := validator.ValidateBytes(s, []byte(`{"params": ["a"]}`))
+	assert.Nil(errPos)
+
+	validator = NewSchemaValidator()
+	errPos = validator.ValidateBytes(s, []byte(`{"params": []}`))
+	assert.NotNil(errPos)
+	assert.Equal("length of params mismatch", errPos.hint)
+
+	mapv := methodSchema.Params[2].Map()
+	assert.Equal(true, mapv["default"])
+}
@@ -35,12 +35,16 @@ type Schema interface {
 	GetName() string
 	SetDescription(desc string)
 	GetDescription() string
+	SetDefault(v any)
+	GetDefault() (any, bool)
 	Equal(other Schema) bool
 }
 
 type SchemaMixin struct {
-	name        string
-	description string
+	name         string
+	description  string
+	defaultValue any
+	hasDefault   bool
 }
 
 // schema subclasses
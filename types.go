@@ -101,6 +101,10 @@ type RequestMessage struct {
 	paramsAreList bool
 
 	// request specific fields
+
+	// Timeout is the milliseconds the caller is willing to wait,
+	// sent as the "timeout" extension field, 0 means unknown
+	Timeout int64
 }
 
 // Notify message kind
@@ -122,9 +122,10 @@ func NewUuid() string {
 
 func DecodeInterface(input any, output any) error {
 	config := &mapstructure.DecoderConfig{
-		Metadata: nil,
-		TagName:  "json",
-		Result:   output,
+		Metadata:   nil,
+		TagName:    "json",
+		Result:     output,
+		DecodeHook: decodeHook,
 	}
 	decoder, err := mapstructure.NewDecoder(config)
 	if err != nil {
@@ -156,23 +157,13 @@ func DecodeParams(params []any, outputPtr any) error {
 		}
 		param := params[idx]
 		idx++
-		ov := reflect.Zero(field.Type).Interface()
-		config := &mapstructure.DecoderConfig{
-			Metadata: nil,
-			TagName:  "json",
-			Result:   &ov,
-		}
-		decoder, err := mapstructure.NewDecoder(config)
-		if err != nil {
-			return errors.Wrap(err, "NewDecoder")
-		}
-		err = decoder.Decode(param)
+		ov := reflect.New(field.Type)
+		err := DecodeInterface(param, ov.Interface())
 		if err != nil {
 			return errors.Wrap(err, "mapstruct.Decode")
 		}
 
-		ptrValue.Elem().FieldByIndex(field.Index).Set(
-			reflect.ValueOf(ov))
+		ptrValue.Elem().FieldByIndex(field.Index).Set(ov.Elem())
 	}
 	return nil
 }